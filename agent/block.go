@@ -0,0 +1,71 @@
+package agent
+
+import "github.com/RomanLorens/logviewer/common"
+
+//MaxContextLines max context lines before or after match
+const MaxContextLines = 100
+
+type numberedLine struct {
+	no   int
+	line string
+}
+
+//blockCollector collects context blocks like grep -C, overlapping blocks are merged
+type blockCollector struct {
+	before    int
+	after     int
+	prev      []numberedLine
+	blocks    []common.ContextBlock
+	afterLeft int
+	lastNo    int
+}
+
+func newBlockCollector(before int, after int) *blockCollector {
+	return &blockCollector{before: before, after: after, prev: make([]numberedLine, 0, before+1),
+		blocks: make([]common.ContextBlock, 0)}
+}
+
+func (c *blockCollector) add(no int, line string, matched bool) {
+	if matched {
+		c.addMatch(no, line)
+	} else if c.afterLeft > 0 {
+		c.append(line)
+		c.lastNo = no
+		c.afterLeft--
+	}
+	if c.before == 0 {
+		return
+	}
+	c.prev = append(c.prev, numberedLine{no: no, line: line})
+	if len(c.prev) > c.before {
+		c.prev = c.prev[1:]
+	}
+}
+
+func (c *blockCollector) addMatch(no int, line string) {
+	start := no - c.before
+	if len(c.blocks) == 0 || c.lastNo < start-1 {
+		first := no
+		if len(c.prev) > 0 {
+			first = c.prev[0].no
+		}
+		c.blocks = append(c.blocks, common.ContextBlock{Start: first, Lines: make([]string, 0), Matches: make([]int, 0)})
+	}
+	for _, p := range c.prev {
+		//already in block
+		if p.no <= c.lastNo {
+			continue
+		}
+		c.append(p.line)
+	}
+	b := &c.blocks[len(c.blocks)-1]
+	b.Matches = append(b.Matches, len(b.Lines))
+	c.append(line)
+	c.lastNo = no
+	c.afterLeft = c.after
+}
+
+func (c *blockCollector) append(line string) {
+	b := &c.blocks[len(c.blocks)-1]
+	b.Lines = append(b.Lines, line)
+}
@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//ServeLog streams log as attachment, plain download supports range requests, gzip is compressed on the fly
+func ServeLog(w http.ResponseWriter, r *http.Request, path string, gz bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Could not open log file, %v", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("Could not stat log file, %v", err)
+	}
+	if stat.IsDir() {
+		return fmt.Errorf("%v is a directory", path)
+	}
+	name := filepath.Base(path)
+	if gz {
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", Attachment(name+".gz"))
+		w.Header().Set("Accept-Ranges", "none")
+		GzipCopy(r.Context(), w, f)
+		return nil
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", Attachment(name))
+	http.ServeContent(w, r, name, stat.ModTime(), f)
+	return nil
+}
+
+//GzipCopy compresses reader to response, response is already committed so errors are only logged
+func GzipCopy(ctx context.Context, w io.Writer, r io.Reader) {
+	gw := gzip.NewWriter(w)
+	if _, err := io.Copy(gw, r); err != nil {
+		logger.Error(ctx, "Could not write gzip response, %v", err)
+		return
+	}
+	if err := gw.Close(); err != nil {
+		logger.Error(ctx, "Could not close gzip response, %v", err)
+	}
+}
+
+//Attachment content disposition header of file
+func Attachment(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, name)
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name})
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/parser"
+)
+
+//maxEventLines max lines kept in single event, rest of lines is skipped
+var maxEventLines = 500
+
+type lineScanner interface {
+	Scan() bool
+	Text() string
+	Err() error
+}
+
+//newLineScanner scans lines or multi line events when events is set
+func newLineScanner(f *os.File, ls *model.LogStructure, events bool) lineScanner {
+	s := newScanner(f)
+	if !events || ls == nil {
+		return s
+	}
+	return &eventScanner{scanner: s, ls: ls}
+}
+
+//eventScanner attaches lines not starting with date, like java stack traces, to previous line
+type eventScanner struct {
+	scanner    *bufio.Scanner
+	ls         *model.LogStructure
+	pending    string
+	hasPending bool
+	event      string
+}
+
+func (s *eventScanner) Scan() bool {
+	if !s.hasPending {
+		if !s.scanner.Scan() {
+			return false
+		}
+		s.pending = s.scanner.Text()
+	}
+	s.hasPending = false
+	var sb strings.Builder
+	sb.WriteString(s.pending)
+	lines := 1
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if parser.StartsEvent(line, s.ls) {
+			s.pending = line
+			s.hasPending = true
+			break
+		}
+		if lines < maxEventLines {
+			sb.WriteByte('\n')
+			sb.WriteString(line)
+		}
+		lines++
+	}
+	s.event = sb.String()
+	return true
+}
+
+func (s *eventScanner) Text() string {
+	return s.event
+}
+
+func (s *eventScanner) Err() error {
+	return s.scanner.Err()
+}
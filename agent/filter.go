@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+	"github.com/RomanLorens/logviewer/parser"
+)
+
+//levels severity of known levels, unknown levels pass every level filter
+var levels = map[string]int{"TRACE": 0, "DEBUG": 1, "INFO": 2, "WARN": 3, "WARNING": 3, "ERROR": 4, "SEVERE": 4,
+	"FATAL": 5}
+
+//Filter compiled tail filter
+type Filter struct {
+	re    *regexp.Regexp
+	level int
+	user  string
+	reqid string
+	ls    *model.LogStructure
+}
+
+//NewFilter compiles tail filter, nil when filter is empty, level, user and reqid need log structure
+func NewFilter(f *common.TailFilter, ls *model.LogStructure) (*Filter, error) {
+	if f == nil || *f == (common.TailFilter{}) {
+		return nil, nil
+	}
+	out := &Filter{level: -1, user: strings.ToLower(f.User), reqid: f.ReqID, ls: ls}
+	if f.Regex != "" {
+		re, err := regexp.Compile(f.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("Wrong filter regex, %v", err)
+		}
+		out.re = re
+	}
+	if f.Level != "" {
+		l, ok := levels[strings.ToUpper(f.Level)]
+		if !ok {
+			return nil, fmt.Errorf("Unknown filter level '%v'", f.Level)
+		}
+		out.level = l
+	}
+	if (f.Level != "" || f.User != "" || f.ReqID != "") && ls == nil {
+		return nil, fmt.Errorf("Must pass log structure for level, user or reqid filter")
+	}
+	return out, nil
+}
+
+//Lines matching lines, lines without log structure like stack traces follow previous line
+func (f *Filter) Lines(lines []string) []string {
+	if f == nil {
+		return lines
+	}
+	out := make([]string, 0, len(lines))
+	for i, keep := range f.Keep(lines) {
+		if keep {
+			out = append(out, lines[i])
+		}
+	}
+	return out
+}
+
+//Keep which lines match filter
+func (f *Filter) Keep(lines []string) []bool {
+	out := make([]bool, len(lines))
+	prev := false
+	for i, line := range lines {
+		if f == nil {
+			out[i] = true
+			continue
+		}
+		var l *parser.Line
+		if f.ls != nil {
+			l = parser.Parse(line, f.ls)
+		}
+		if l == nil && f.ls != nil {
+			out[i] = prev
+			continue
+		}
+		prev = f.match(line, l)
+		out[i] = prev
+	}
+	return out
+}
+
+func (f *Filter) match(line string, l *parser.Line) bool {
+	if f.re != nil && !f.re.MatchString(line) {
+		return false
+	}
+	if l == nil {
+		return true
+	}
+	if f.level >= 0 {
+		if lvl, ok := levels[l.Level]; ok && lvl < f.level {
+			return false
+		}
+	}
+	if f.user != "" && strings.ToLower(l.User) != f.user {
+		return false
+	}
+	return f.reqid == "" || l.ReqID == f.reqid
+}
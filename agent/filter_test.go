@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+func TestFilter(t *testing.T) {
+	ls := &model.LogStructure{Date: 0, Level: 2, Message: 6, Reqid: 5, User: 4, DateFormat: "2006-01-02 15:04:05,000"}
+	lines := []string{
+		"2021-05-06 11:27:01,000|main|INFO|c.App|ab12345|r1|started",
+		"2021-05-06 11:27:02,000|main|ERROR|c.App|ab12345|r1|failed",
+		"java.lang.IllegalStateException: boom",
+		"2021-05-06 11:27:03,000|main|WARN|c.App|cd67890|r2|slow call",
+		"	at c.App.run(App.java:10)",
+	}
+	for _, tc := range []struct {
+		filter *common.TailFilter
+		want   []string
+	}{
+		{nil, lines},
+		{&common.TailFilter{}, lines},
+		{&common.TailFilter{Level: "warn"}, lines[1:]},
+		{&common.TailFilter{Level: "ERROR"}, lines[1:3]},
+		{&common.TailFilter{User: "CD67890"}, lines[3:]},
+		{&common.TailFilter{ReqID: "r1", Regex: "fail"}, lines[1:3]},
+	} {
+		f, err := NewFilter(tc.filter, ls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Lines(lines); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("filter %+v, expected %v, got %v", tc.filter, tc.want, got)
+		}
+	}
+}
+
+func TestFilterValidation(t *testing.T) {
+	if _, err := NewFilter(&common.TailFilter{Regex: "("}, nil); err == nil {
+		t.Fatal("wrong regex should fail")
+	}
+	if _, err := NewFilter(&common.TailFilter{Level: "LOUD"}, &model.LogStructure{}); err == nil {
+		t.Fatal("unknown level should fail")
+	}
+	if _, err := NewFilter(&common.TailFilter{User: "ab12345"}, nil); err == nil {
+		t.Fatal("user filter without log structure should fail")
+	}
+	f, err := NewFilter(&common.TailFilter{Regex: "b"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Lines([]string{"a", "b"}); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Fatalf("regex without log structure should filter raw lines, got %v", got)
+	}
+}
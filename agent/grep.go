@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer-module/search"
+	"github.com/RomanLorens/logviewer/common"
+	l "github.com/RomanLorens/logviewer/logger"
+	"github.com/RomanLorens/logviewer/parser"
+	"github.com/RomanLorens/logviewer/query"
+)
+
+var (
+	logger = l.L
+	//maxLineSize max size of single log line
+	maxLineSize = 1024 * 1024
+)
+
+//Grep greps logs by value and query
+func Grep(ctx context.Context, req *common.GrepRequest) ([]common.GrepResponse, error) {
+	m, err := newMatcher(req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Before < 0 || req.After < 0 || req.Before > MaxContextLines || req.After > MaxContextLines {
+		return nil, fmt.Errorf("Context lines must be between 0 and %v", MaxContextLines)
+	}
+	out := make([]common.GrepResponse, 0, len(req.Logs))
+	total := 0
+	for _, log := range req.Logs {
+		limit := 0
+		if req.MaxResults > 0 {
+			if total >= req.MaxResults {
+				break
+			}
+			limit = req.MaxResults - total
+		}
+		logger.Info(ctx, "Local grep for %v - '%v' '%v'", log, req.Value, req.Query)
+		var bc *blockCollector
+		if req.Before > 0 || req.After > 0 {
+			bc = newBlockCollector(req.Before, req.After)
+		}
+		lines, err := grepFile(ctx, log, m, bc, limit)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logger.Error(ctx, "Could not grep %v, %v", log, err)
+			continue
+		}
+		total += len(lines)
+		r := common.GrepResponse{GrepResponse: model.GrepResponse{LogFile: log, Lines: lines}}
+		if bc != nil {
+			r.Blocks = bc.blocks
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+type matcher struct {
+	value  string
+	expr   query.Expr
+	ls     *model.LogStructure
+	window common.TimeWindow
+	//lines without date belong to previous dated line
+	inWindow bool
+	events   bool
+}
+
+func newMatcher(req *common.GrepRequest) (*matcher, error) {
+	m := &matcher{value: strings.ToLower(req.Value), ls: req.LogStructure, window: req.TimeWindow, events: req.Events}
+	if req.Query == "" && !req.IsSet() && !req.Events {
+		return m, nil
+	}
+	if req.LogStructure == nil {
+		return nil, fmt.Errorf("Must pass log structure for query, time window or events")
+	}
+	if req.Query == "" {
+		return m, nil
+	}
+	e, err := query.Parse(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	m.expr = e
+	return m, nil
+}
+
+func (m *matcher) match(line string) bool {
+	var l *parser.Line
+	if m.window.IsSet() {
+		l = parser.ParseEvent(line, m.ls)
+		if l != nil && !l.Time.IsZero() {
+			m.inWindow = m.window.Contains(l.Time)
+		}
+		if !m.inWindow {
+			return false
+		}
+	}
+	if m.value != "" && !strings.Contains(strings.ToLower(line), m.value) {
+		return false
+	}
+	if m.expr == nil {
+		return true
+	}
+	if l == nil {
+		l = parser.ParseEvent(line, m.ls)
+	}
+	return l != nil && m.expr.Match(l)
+}
+
+//grepFile greps file, stops after limit matches when limit is set,
+//with events line numbers are numbers of events
+func grepFile(ctx context.Context, path string, m *matcher, bc *blockCollector, limit int) ([]string, error) {
+	out := make([]string, 0, 20)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m.inWindow = false
+	scanner := newLineScanner(f, m.ls, m.events)
+	for no := 1; scanner.Scan(); no++ {
+		if no%1000 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		line := search.NormalizeText(scanner.Text())
+		if limit > 0 && len(out) >= limit && (bc == nil || bc.afterLeft == 0) {
+			break
+		}
+		matched := m.match(line) && (limit == 0 || len(out) < limit)
+		if matched {
+			out = append(out, line)
+		}
+		if bc != nil {
+			bc.add(no, line, matched)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func newScanner(f *os.File) *bufio.Scanner {
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	return scanner
+}
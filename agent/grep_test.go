@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+func writeLog(t *testing.T, lines ...string) string {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGrepContextBlocks(t *testing.T) {
+	log := writeLog(t, "1", "2", "3 match", "4", "5 match", "6", "7", "8", "9", "10 match", "11")
+	req := &common.GrepRequest{GrepRequest: model.GrepRequest{Value: "match", Logs: []string{log}}, Before: 1, After: 1}
+	res, err := Grep(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res[0].Lines) != 3 {
+		t.Fatalf("expected 3 matches, got %v", res[0].Lines)
+	}
+	expected := []common.ContextBlock{
+		{Start: 2, Lines: []string{"2", "3 match", "4", "5 match", "6"}, Matches: []int{1, 3}},
+		{Start: 9, Lines: []string{"9", "10 match", "11"}, Matches: []int{1}},
+	}
+	if !reflect.DeepEqual(res[0].Blocks, expected) {
+		t.Fatalf("wrong blocks %+v", res[0].Blocks)
+	}
+}
+
+func TestGrepContextAdjacentMatches(t *testing.T) {
+	log := writeLog(t, "a match", "b match", "c", "d")
+	req := &common.GrepRequest{GrepRequest: model.GrepRequest{Value: "match", Logs: []string{log}}, Before: 2, After: 1}
+	res, err := Grep(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []common.ContextBlock{{Start: 1, Lines: []string{"a match", "b match", "c"}, Matches: []int{0, 1}}}
+	if !reflect.DeepEqual(res[0].Blocks, expected) {
+		t.Fatalf("wrong blocks %+v", res[0].Blocks)
+	}
+}
+
+var ls = &model.LogStructure{Date: 0, Level: 2, Message: 6, Reqid: 5, User: 4, DateFormat: "2006-01-02 15:04:05,000"}
+
+func stackTraceLog(t *testing.T) string {
+	return writeLog(t,
+		"2021-04-26 10:29:50,782|exec-1|INFO|c.c.LogFilter|ab12345|1-01@6|[POST] /bcs/query",
+		"2021-04-26 10:29:50,785|exec-1|ERROR|c.c.Handler|ab12345|1-01@6|Request failed",
+		"java.lang.NullPointerException: trackingId",
+		"\tat c.c.Handler.handle(Handler.java:42)",
+		"2021-04-26 10:29:50,786|exec-1|INFO|c.c.LogFilter|ab12345|1-01@6|/bcs/query 500")
+}
+
+func TestGrepEvents(t *testing.T) {
+	req := &common.GrepRequest{GrepRequest: model.GrepRequest{Value: "NullPointerException", Logs: []string{stackTraceLog(t)}},
+		LogStructure: ls, Events: true}
+	res, err := Grep(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res[0].Lines) != 1 {
+		t.Fatalf("expected 1 event, got %v", res[0].Lines)
+	}
+	if !strings.HasPrefix(res[0].Lines[0], "2021-04-26 10:29:50,785") || !strings.HasSuffix(res[0].Lines[0], "(Handler.java:42)") {
+		t.Fatalf("event should contain whole exception, got '%v'", res[0].Lines[0])
+	}
+}
+
+func TestErrorsEvents(t *testing.T) {
+	req := &common.AgentErrorsRequest{ErrorsRequest: &model.ErrorsRequest{Size: 10,
+		StatsRequest: &model.StatsRequest{Log: stackTraceLog(t), LogStructure: ls}}, Events: true}
+	res, err := Errors(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.ErrorDetails) != 1 {
+		t.Fatalf("expected 1 error, got %v", res.ErrorDetails)
+	}
+	if res.ErrorDetails[0].Message != "Request failed\njava.lang.NullPointerException: trackingId\n\tat c.c.Handler.handle(Handler.java:42)" {
+		t.Fatalf("wrong message '%v'", res.ErrorDetails[0].Message)
+	}
+}
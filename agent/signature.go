@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+//maxSignatureLength max length of error signature
+const maxSignatureLength = 300
+
+var (
+	uuidRe    = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	paramIDRe = regexp.MustCompile(`(?i)\b(trackingId|requestId|reqid|id)=[^&\s,;]+`)
+	//long tokens like hex or tracking ids
+	tokenRe  = regexp.MustCompile(`[A-Za-z0-9][A-Za-z0-9_\-@#]{11,}`)
+	numberRe = regexp.MustCompile(`\d+`)
+	spaceRe  = regexp.MustCompile(`\s+`)
+)
+
+//Signature normalizes variable parts of error message like ids, uuids and numbers
+func Signature(msg string) string {
+	msg = uuidRe.ReplaceAllString(msg, "<uuid>")
+	msg = paramIDRe.ReplaceAllString(msg, "$1=<id>")
+	msg = tokenRe.ReplaceAllStringFunc(msg, func(t string) string {
+		if strings.IndexAny(t, "0123456789") >= 0 {
+			return "<id>"
+		}
+		return t
+	})
+	msg = numberRe.ReplaceAllString(msg, "<n>")
+	msg = strings.TrimSpace(spaceRe.ReplaceAllString(msg, " "))
+	if len(msg) > maxSignatureLength {
+		//cut on rune boundary so signature stays valid utf8
+		n := maxSignatureLength
+		for n > 0 && !utf8.RuneStart(msg[n]) {
+			n--
+		}
+		msg = msg[:n]
+	}
+	return msg
+}
+
+//groupErrors groups errors by level and signature, errors must be sorted from oldest
+func groupErrors(errors []model.ErrorDetails) []common.ErrorGroup {
+	groups := make(map[string]*common.ErrorGroup)
+	users := make(map[string]map[string]bool)
+	keys := make([]string, 0)
+	for _, e := range errors {
+		sig := Signature(e.Message)
+		key := e.Level + "|" + sig
+		g, ok := groups[key]
+		if !ok {
+			g = &common.ErrorGroup{Signature: sig, Level: e.Level, FirstSeen: e.Date, Sample: e, Users: make([]string, 0)}
+			groups[key] = g
+			users[key] = make(map[string]bool)
+			keys = append(keys, key)
+		}
+		g.Count++
+		g.LastSeen = e.Date
+		if e.User != "" && !users[key][e.User] {
+			users[key][e.User] = true
+			g.Users = append(g.Users, e.User)
+		}
+	}
+	out := make([]common.ErrorGroup, 0, len(groups))
+	for _, k := range keys {
+		sort.Strings(groups[k].Users)
+		out = append(out, *groups[k])
+	}
+	sortGroups(out)
+	return out
+}
+
+//sortGroups most frequent first, latest first for same count
+func sortGroups(groups []common.ErrorGroup) {
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].LastSeen > groups[j].LastSeen
+	})
+}
@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+func TestSignature(t *testing.T) {
+	a := Signature("Timeout after 3000 ms for trackingId=ABC123XYZ987 order 6f1c2a3b-1d2e-4f5a-8b9c-0d1e2f3a4b5c")
+	b := Signature("Timeout  after 15 ms for trackingId=QQ11 order 11111111-2222-3333-4444-555555555555")
+	if a != b {
+		t.Fatalf("signatures differ '%v' vs '%v'", a, b)
+	}
+	if a != "Timeout after <n> ms for trackingId=<id> order <uuid>" {
+		t.Fatalf("wrong signature '%v'", a)
+	}
+	if s := Signature("Failed for 1-01-CV-QCVRJWVM9YJJMRFJC7JVW766EBVJJBG4139908801@1-694984#6"); s != "Failed for <id>" {
+		t.Fatalf("wrong signature '%v'", s)
+	}
+}
+
+func TestSignatureRuneBoundary(t *testing.T) {
+	s := Signature("x" + strings.Repeat("é", maxSignatureLength))
+	if !utf8.ValidString(s) || len(s) != maxSignatureLength-1 {
+		t.Fatalf("signature should be cut on rune boundary, got %v bytes, valid %v", len(s), utf8.ValidString(s))
+	}
+}
+
+func TestErrorGroups(t *testing.T) {
+	log := writeLog(t,
+		"2021-04-26 10:29:50,785|exec-1|ERROR|c.c.Handler|ab12345|1-01@1|Timeout after 3000 ms",
+		"2021-04-26 10:30:50,785|exec-1|ERROR|c.c.Handler|cd12345|1-01@2|Timeout after 15 ms",
+		"2021-04-26 10:31:50,785|exec-1|ERROR|c.c.Handler|ab12345|1-01@3|Timeout after 7 ms",
+		"2021-04-26 10:32:50,785|exec-1|WARN|c.c.Handler|ab12345|1-01@4|Slow query 120 ms",
+		"2021-04-26 10:33:50,785|exec-1|INFO|c.c.Handler|ab12345|1-01@5|Timeout after 1 ms")
+	req := &common.AgentErrorsRequest{ErrorsRequest: &model.ErrorsRequest{Size: 10,
+		StatsRequest: &model.StatsRequest{Log: log, LogStructure: ls}}, Group: true}
+	res, err := ErrorGroups(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Groups) != 2 || res.Pagination.Total != 2 {
+		t.Fatalf("expected 2 groups, got %+v", res.Groups)
+	}
+	g := res.Groups[0]
+	if g.Signature != "Timeout after <n> ms" || g.Level != "ERROR" || g.Count != 3 {
+		t.Fatalf("wrong group %+v", g)
+	}
+	if g.FirstSeen != "2021-04-26 10:29:50,785" || g.LastSeen != "2021-04-26 10:31:50,785" {
+		t.Fatalf("wrong first/last seen %+v", g)
+	}
+	if len(g.Users) != 2 || g.Users[0] != "ab12345" || g.Users[1] != "cd12345" {
+		t.Fatalf("wrong users %v", g.Users)
+	}
+	if g.Sample.Message != "Timeout after 3000 ms" {
+		t.Fatalf("wrong sample %+v", g.Sample)
+	}
+}
@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+	"github.com/RomanLorens/logviewer/parser"
+)
+
+//ScanGrace lines of multi threaded logs are not strictly in time order, scan of time window stops only at line
+//logged later than end of window by more than grace
+var ScanGrace = time.Minute
+
+//Errors first error or warning per request id, latest first
+func Errors(ctx context.Context, req *common.AgentErrorsRequest) (*model.ErrorDetailsPagination, error) {
+	res, err := collectErrors(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return paginate(res, req.From, req.Size), nil
+}
+
+//ErrorGroups errors grouped by signature, most frequent first
+func ErrorGroups(ctx context.Context, req *common.AgentErrorsRequest) (*common.ErrorGroupsPagination, error) {
+	res, err := collectErrors(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return paginateGroups(groupErrors(res), req.From, req.Size), nil
+}
+
+//collectErrors first error or warning per request id, oldest first
+func collectErrors(ctx context.Context, req *common.AgentErrorsRequest) ([]model.ErrorDetails, error) {
+	if req.ErrorsRequest == nil || req.StatsRequest == nil || req.LogStructure == nil {
+		return nil, fmt.Errorf("Must pass log and log structure")
+	}
+	res := make([]model.ErrorDetails, 0, 100)
+	requests := make(map[string]int, 0)
+	err := scanLog(ctx, req.Log, req.LogStructure, req.TimeWindow, req.Events, func(l *parser.Line) {
+		if !isError(l.Level) || (req.Before > 0 && !l.Time.IsZero() && parser.Millis(l.Time) > req.Before) {
+			return
+		}
+		requests[l.ReqID+l.Level]++
+		if requests[l.ReqID+l.Level] > 1 {
+			return
+		}
+		res = append(res, model.ErrorDetails{
+			ReqID:   model.ReqID{ReqID: l.ReqID, Date: l.Date},
+			Level:   l.Level,
+			Message: l.Message,
+			User:    l.User,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+//Stats requests per user and level
+func Stats(ctx context.Context, req *common.AgentStatsRequest) (map[string]*model.Stat, error) {
+	if req.StatsRequest == nil || req.LogStructure == nil {
+		return nil, fmt.Errorf("Must pass log and log structure")
+	}
+	out := make(map[string]*model.Stat)
+	requests := make(map[string]int, 0)
+	err := scanLog(ctx, req.Log, req.LogStructure, req.TimeWindow, false, func(l *parser.Line) {
+		if l.User == "" {
+			return
+		}
+		u, ok := out[l.User]
+		if !ok {
+			u = &model.Stat{Levels: make(map[string]int, 0)}
+			out[l.User] = u
+		}
+		key := l.ReqID + l.Level + l.User
+		requests[key]++
+		if requests[key] > 1 {
+			return
+		}
+		u.LastTime = l.Date
+		u.Counter++
+		u.Levels[l.Level]++
+		if l.Level == "ERROR" {
+			u.Errors = append(u.Errors, model.ReqID{ReqID: l.ReqID, Date: l.Date})
+		}
+		if l.Level == "WARNING" || l.Level == "WARN" {
+			u.Warnings = append(u.Warnings, model.ReqID{ReqID: l.ReqID, Date: l.Date})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range out {
+		for i, j := 0, len(v.Errors)-1; i < j; i, j = i+1, j-1 {
+			v.Errors[i], v.Errors[j] = v.Errors[j], v.Errors[i]
+		}
+		for i, j := 0, len(v.Warnings)-1; i < j; i, j = i+1, j-1 {
+			v.Warnings[i], v.Warnings[j] = v.Warnings[j], v.Warnings[i]
+		}
+	}
+	return out, nil
+}
+
+//scanLog calls fn for every structured line or event inside time window
+func scanLog(ctx context.Context, log string, ls *model.LogStructure, window common.TimeWindow, events bool, fn func(l *parser.Line)) error {
+	file, err := os.Open(log)
+	if err != nil {
+		return fmt.Errorf("Could not open log file, %v", err)
+	}
+	defer file.Close()
+	scanner := newLineScanner(file, ls, events)
+	for i := 0; scanner.Scan(); i++ {
+		if i%1000 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		l := parser.ParseEvent(scanner.Text(), ls)
+		if l == nil {
+			continue
+		}
+		//log is written in near time order, nothing long after end of window can match
+		if window.ToTime > 0 && !l.Time.IsZero() && l.Time.After(time.Unix(window.ToTime, 0).Add(ScanGrace)) {
+			break
+		}
+		if window.IsSet() && (l.Time.IsZero() || !window.Contains(l.Time)) {
+			continue
+		}
+		fn(l)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error from scanner, %v", err)
+	}
+	return nil
+}
+
+func isError(level string) bool {
+	return level == "ERROR" || level == "WARNING" || level == "WARN"
+}
+
+func paginate(res []model.ErrorDetails, from int, size int) *model.ErrorDetailsPagination {
+	pagination := &model.Pagination{
+		From:  from,
+		Size:  size,
+		Total: len(res),
+	}
+	start := from * size
+	end := start + size
+	if end >= len(res) {
+		end = len(res)
+	}
+	if start >= end {
+		return &model.ErrorDetailsPagination{ErrorDetails: []model.ErrorDetails{}, Pagination: pagination}
+	}
+	return &model.ErrorDetailsPagination{ErrorDetails: res[start:end], Pagination: pagination}
+}
+
+//paginateGroups page of error groups
+func paginateGroups(groups []common.ErrorGroup, from int, size int) *common.ErrorGroupsPagination {
+	pagination := &model.Pagination{
+		From:  from,
+		Size:  size,
+		Total: len(groups),
+	}
+	start := from * size
+	end := start + size
+	if end >= len(groups) {
+		end = len(groups)
+	}
+	if start >= end {
+		return &common.ErrorGroupsPagination{Groups: []common.ErrorGroup{}, Pagination: pagination}
+	}
+	return &common.ErrorGroupsPagination{Groups: groups[start:end], Pagination: pagination}
+}
@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+	"github.com/RomanLorens/logviewer/parser"
+)
+
+func TestErrorsBefore(t *testing.T) {
+	log := writeLog(t, "2021-05-06 11:27:01,000|main|ERROR|c.App|ab12345|r1|early",
+		"2021-05-06 11:27:05,000|main|ERROR|c.App|ab12345|r2|late",
+		"bad date|main|ERROR|c.App|ab12345|r3|no time")
+	before, _ := time.ParseInLocation("2006-01-02 15:04:05", "2021-05-06 11:27:02", time.Local)
+	req := &common.AgentErrorsRequest{ErrorsRequest: &model.ErrorsRequest{Size: 10,
+		StatsRequest: &model.StatsRequest{Log: log, LogStructure: ls}}, Before: parser.Millis(before)}
+	res, err := Errors(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Pagination.Total != 2 || res.ErrorDetails[0].ReqID.ReqID != "r3" || res.ErrorDetails[1].ReqID.ReqID != "r1" {
+		t.Fatalf("lines without time should be kept and later ones left out, got %+v", res.ErrorDetails)
+	}
+}
+
+func TestStatsOutOfOrderLines(t *testing.T) {
+	log := writeLog(t, "2021-05-06 11:27:01,000|main|INFO|c.App|ab12345|r1|in window",
+		"2021-05-06 11:27:03,000|main|INFO|c.App|ab12345|r2|other thread after window",
+		"2021-05-06 11:27:01,500|main|INFO|c.App|ab12345|r3|in window logged late",
+		"2021-05-06 11:29:00,000|main|INFO|c.App|ab12345|r4|long after window",
+		"2021-05-06 11:27:01,800|main|INFO|c.App|ab12345|r5|past grace")
+	from, _ := time.ParseInLocation("2006-01-02 15:04:05", "2021-05-06 11:27:00", time.Local)
+	req := &common.AgentStatsRequest{StatsRequest: &model.StatsRequest{Log: log, LogStructure: ls},
+		TimeWindow: common.TimeWindow{FromTime: from.Unix(), ToTime: from.Unix() + 2}}
+	res, err := Stats(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["ab12345"] == nil || res["ab12345"].Counter != 2 {
+		t.Fatalf("lines inside window after later line should be counted until grace is exceeded, got %+v", res["ab12345"])
+	}
+}
@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer-module/search"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+var (
+	//TailSize bytes of last lines returned without cursor
+	TailSize int64 = 16 * 1024
+	//MaxTailRead max bytes returned by single incremental tail, rest is returned by next call
+	MaxTailRead int64 = 1024 * 1024
+	//fingerprintSize bytes from start of file identifying it, same file keeps its first bytes while it grows
+	fingerprintSize int64 = 1024
+)
+
+//Tail lines appended after cursor with next cursor, without cursor or with empty one last lines of log are returned.
+//Rotated log is reported and rest of rotated file is returned first when it is found next to log,
+//log smaller than cursor offset is reported as truncated and read from start.
+//Only lines matching filter are returned, cursor still moves over all read lines
+func Tail(log string, c *common.TailCursor, f *Filter) (*common.TailResponse, error) {
+	start := time.Now()
+	out, err := tailCursor(log, c)
+	if err != nil {
+		return nil, err
+	}
+	out.Lines, out.Filtered = f.Lines(out.Lines), f != nil
+	out.Time = time.Since(start).Milliseconds()
+	return out, nil
+}
+
+func tailCursor(log string, c *common.TailCursor) (*common.TailResponse, error) {
+	f, err := os.Open(log)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file %v", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Could not stat file %v", err)
+	}
+	out := &common.TailResponse{TailLogResponse: model.TailLogResponse{LogFile: log}}
+
+	if c == nil || c.File == "" {
+		from := stat.Size() - TailSize
+		if from < 0 {
+			from = 0
+		}
+		return out, tailFile(out, f, stat.Size(), from, from > 0)
+	}
+	same, err := sameFile(f, stat.Size(), c.File)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case same && stat.Size() >= c.Offset:
+		return out, tailFile(out, f, stat.Size(), c.Offset, false)
+	case same:
+		out.Truncated = true
+	case stat.Size() < fingerprintLen(c.File):
+		//shrank below fingerprint, like copytruncate
+		out.Truncated = true
+	default:
+		out.Rotated = true
+	}
+	if old := rotatedFile(log, c.File); old != "" {
+		done, err := tailRotated(out, old, c)
+		if err != nil || !done {
+			return out, err
+		}
+	}
+	lines := out.Lines
+	err = tailFile(out, f, stat.Size(), 0, false)
+	out.Lines = append(lines, out.Lines...)
+	return out, err
+}
+
+//tailRotated rest of rotated file after cursor, false when more lines are left in rotated file
+func tailRotated(out *common.TailResponse, path string, c *common.TailCursor) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return true, nil
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil || stat.Size() <= c.Offset {
+		return true, nil
+	}
+	if err := readLines(out, f, stat.Size(), c.Offset, false, true); err != nil {
+		return false, err
+	}
+	if out.More {
+		out.Cursor.File = c.File
+		return false, nil
+	}
+	return true, nil
+}
+
+//tailFile lines from offset to size, next cursor points after last complete line
+func tailFile(out *common.TailResponse, f *os.File, size int64, from int64, skipPartial bool) error {
+	if err := readLines(out, f, size, from, skipPartial, false); err != nil {
+		return err
+	}
+	id, err := fingerprint(f, size)
+	if err != nil {
+		return err
+	}
+	out.Cursor.File = id
+	return nil
+}
+
+//readLines reads at most MaxTailRead bytes, partial last line is left for next read unless file is final
+func readLines(out *common.TailResponse, f *os.File, size int64, from int64, skipPartial bool, final bool) error {
+	to := size
+	if to-from > MaxTailRead {
+		to = from + MaxTailRead
+		out.More = true
+	}
+	buf := make([]byte, to-from)
+	if _, err := f.ReadAt(buf, from); err != nil && err != io.EOF {
+		return fmt.Errorf("Could not read file %v", err)
+	}
+	next := from
+	if skipPartial {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			i = len(buf) - 1
+		}
+		buf = buf[i+1:]
+		next += int64(i + 1)
+	}
+	if !final || out.More {
+		//unfinished last line is read again by next call, unless it alone exceeds max read
+		switch i := bytes.LastIndexByte(buf, '\n'); {
+		case i >= 0:
+			buf = buf[:i+1]
+		case !out.More:
+			buf = buf[:0]
+		}
+	}
+	next += int64(len(buf))
+	out.Cursor = &common.TailCursor{Offset: next}
+	out.Lines = make([]string, 0)
+	for _, l := range strings.Split(string(buf), "\n") {
+		l = search.NormalizeText(strings.TrimSuffix(l, "\r"))
+		if strings.TrimSpace(l) != "" {
+			out.Lines = append(out.Lines, l)
+		}
+	}
+	return nil
+}
+
+//fingerprint length and hash of first bytes of file
+func fingerprint(f *os.File, size int64) (string, error) {
+	n := size
+	if n > fingerprintSize {
+		n = fingerprintSize
+	}
+	buf := make([]byte, n)
+	if _, err := f.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return "", fmt.Errorf("Could not read file %v", err)
+	}
+	sum := sha1.Sum(buf)
+	return fmt.Sprintf("%v:%v", n, hex.EncodeToString(sum[:])), nil
+}
+
+func fingerprintLen(id string) int64 {
+	i := strings.IndexByte(id, ':')
+	if i < 0 {
+		return 0
+	}
+	n, _ := strconv.ParseInt(id[:i], 10, 64)
+	return n
+}
+
+//sameFile first bytes of file match fingerprint of cursor
+func sameFile(f *os.File, size int64, id string) (bool, error) {
+	n := fingerprintLen(id)
+	if size < n {
+		return false, nil
+	}
+	fp, err := fingerprint(f, n)
+	if err != nil {
+		return false, err
+	}
+	return fp == id, nil
+}
+
+//rotatedFile file next to log with fingerprint of cursor, empty when not found
+func rotatedFile(log string, id string) string {
+	if fingerprintLen(id) == 0 {
+		return ""
+	}
+	dir := filepath.Dir(log)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, fi := range files {
+		path := filepath.Join(dir, fi.Name())
+		if fi.IsDir() || fi.Size() < fingerprintLen(id) || filepath.Clean(path) == filepath.Clean(log) {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		same, err := sameFile(f, fi.Size(), id)
+		f.Close()
+		if err == nil && same {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/RomanLorens/logviewer/common"
+)
+
+func appendLog(t *testing.T, path string, s string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tail(t *testing.T, log string, c *common.TailCursor) *common.TailResponse {
+	res, err := Tail(log, c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestTailCursor(t *testing.T) {
+	log := writeLog(t, "1", "2")
+	res := tail(t, log, &common.TailCursor{})
+	if !reflect.DeepEqual(res.Lines, []string{"1", "2"}) || res.Cursor == nil || res.Cursor.Offset != 4 {
+		t.Fatalf("wrong first tail %+v", res)
+	}
+	if res = tail(t, log, res.Cursor); len(res.Lines) != 0 {
+		t.Fatalf("no new lines expected, got %v", res.Lines)
+	}
+	appendLog(t, log, "3\n4 partial")
+	res = tail(t, log, res.Cursor)
+	if !reflect.DeepEqual(res.Lines, []string{"3"}) {
+		t.Fatalf("only new complete lines expected, got %v", res.Lines)
+	}
+	appendLog(t, log, " line\n")
+	if res = tail(t, log, res.Cursor); !reflect.DeepEqual(res.Lines, []string{"4 partial line"}) || res.Rotated || res.Truncated {
+		t.Fatalf("wrong tail after partial line %+v", res)
+	}
+}
+
+func TestTailRotation(t *testing.T) {
+	log := writeLog(t, "old 1")
+	c := tail(t, log, &common.TailCursor{}).Cursor
+	appendLog(t, log, "old 2\n")
+	if err := os.Rename(log, log+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(log, []byte("new 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res := tail(t, log, c)
+	if !res.Rotated || !reflect.DeepEqual(res.Lines, []string{"old 2", "new 1"}) {
+		t.Fatalf("rest of rotated file and new lines expected %+v", res)
+	}
+
+	c = res.Cursor
+	if err := ioutil.WriteFile(log, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if res = tail(t, log, c); !res.Truncated || res.Rotated || res.Cursor.Offset != 0 {
+		t.Fatalf("truncation expected %+v", res)
+	}
+}
+
+func TestTailMaxRead(t *testing.T) {
+	log := writeLog(t, "1", "2", "3")
+	c := tail(t, log, &common.TailCursor{}).Cursor
+	c.Offset = 0
+	defer func(max int64) { MaxTailRead = max }(MaxTailRead)
+	MaxTailRead = 4
+	res := tail(t, log, c)
+	if !res.More || !reflect.DeepEqual(res.Lines, []string{"1", "2"}) {
+		t.Fatalf("read should stop at max %+v", res)
+	}
+	if res = tail(t, log, res.Cursor); res.More || !reflect.DeepEqual(res.Lines, []string{"3"}) {
+		t.Fatalf("rest expected %+v", res)
+	}
+}
@@ -2,6 +2,7 @@ package common
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/RomanLorens/logviewer-module/model"
 )
@@ -16,6 +17,13 @@ type Stats struct {
 	CreatedOn string                    `json:"createdOn" bson:"createdOn"`
 }
 
+const (
+	//RequestIDHeader request id header
+	RequestIDHeader = "x-citiportal-requestid"
+	//OriginalUserHeader user on whose behalf aggregator calls agent, for audit only
+	OriginalUserHeader = "x-logviewer-original-user"
+)
+
 //StatsTemplate stats template
 type StatsTemplate struct {
 	Stats
@@ -23,6 +31,12 @@ type StatsTemplate struct {
 	Users       []UserTotalRequests
 }
 
+//StatsEmail stats email data, totals per application and per log
+type StatsEmail struct {
+	Apps []StatsTemplate
+	Logs []StatsTemplate
+}
+
 //UserTotalRequests user requests count
 type UserTotalRequests struct {
 	User  string
@@ -47,15 +61,134 @@ type AppCollectStatsRequest struct {
 //StatsRequest stats request
 type StatsRequest struct {
 	*model.StatsRequest
+	TimeWindow
 	LogViewerEndpoint string `json:"endpoint"`
 }
 
 //ErrorsRequest errors request
 type ErrorsRequest struct {
 	*model.StatsRequest
+	TimeWindow
 	LogViewerEndpoint string `json:"endpoint"`
 	From              int    `json:"from"`
 	Size              int    `json:"size"`
+	Events            bool   `json:"events"`
+	//Group groups errors by message signature
+	Group bool `json:"group"`
+}
+
+//AgentStatsRequest agent stats request
+type AgentStatsRequest struct {
+	*model.StatsRequest
+	TimeWindow
+}
+
+//AgentErrorsRequest agent errors request
+type AgentErrorsRequest struct {
+	*model.ErrorsRequest
+	TimeWindow
+	Events bool `json:"events,omitempty"`
+	Group  bool `json:"group,omitempty"`
+	//Before millis, errors of lines with time logged later are left out, lines without time are kept
+	Before int64 `json:"before,omitempty"`
+}
+
+//AppErrorsRequest errors from all hosts and logs of application
+type AppErrorsRequest struct {
+	AppRequest
+	TimeWindow
+	Size   int  `json:"size"`
+	Events bool `json:"events"`
+	//Next cursor returned with previous page, first page when empty
+	Next *AppErrorsCursor `json:"next,omitempty"`
+}
+
+//AppErrorsCursor position after last error of page, errors logged after first page never show up on next pages
+type AppErrorsCursor struct {
+	//Time millis of last error, 0 when its time could not be parsed
+	Time int64 `json:"time"`
+	//Skip errors with same time already served
+	Skip int `json:"skip"`
+}
+
+//AppError error with host and log it comes from
+type AppError struct {
+	model.ErrorDetails
+	Time    int64  `json:"time"`
+	Host    string `json:"host"`
+	LogFile string `json:"logfile"`
+}
+
+//AppErrorsResponse merged errors from all application hosts, latest first
+type AppErrorsResponse struct {
+	Errors []AppError `json:"errors"`
+	//Pagination total is count of errors from this page on
+	Pagination *model.Pagination `json:"pagination"`
+	//Next cursor of next page, empty on last page
+	Next  *AppErrorsCursor `json:"next,omitempty"`
+	Hosts []HostStatus     `json:"hosts"`
+}
+
+//AppStatsRequest stats from all hosts and logs of application
+type AppStatsRequest struct {
+	AppRequest
+	TimeWindow
+}
+
+//LogStats stats of single log on host
+type LogStats struct {
+	Host     string                 `json:"host"`
+	Endpoint string                 `json:"endpoint"`
+	LogFile  string                 `json:"logfile"`
+	Stats    map[string]*model.Stat `json:"stats"`
+}
+
+//AppStatsResponse per user stats merged from all application hosts with per log breakdown
+type AppStatsResponse struct {
+	App    string                 `json:"app"`
+	Env    string                 `json:"env"`
+	Totals map[string]*model.Stat `json:"totals"`
+	Logs   []LogStats             `json:"logs"`
+	Hosts  []HostStatus           `json:"hosts"`
+}
+
+//ErrorGroup errors with same level and normalized message
+type ErrorGroup struct {
+	Signature string             `json:"signature"`
+	Level     string             `json:"level"`
+	Count     int                `json:"count"`
+	FirstSeen string             `json:"firstSeen"`
+	LastSeen  string             `json:"lastSeen"`
+	Users     []string           `json:"users"`
+	Sample    model.ErrorDetails `json:"sample"`
+}
+
+//ErrorGroupsPagination error groups with pagination, most frequent first
+type ErrorGroupsPagination struct {
+	Groups     []ErrorGroup      `json:"groups"`
+	Pagination *model.Pagination `json:"pagination"`
+}
+
+//TimeWindow unix seconds time window, zero bound is open
+type TimeWindow struct {
+	FromTime int64 `json:"fromTime,omitempty"`
+	ToTime   int64 `json:"toTime,omitempty"`
+}
+
+//IsSet any bound is set
+func (tw TimeWindow) IsSet() bool {
+	return tw.FromTime > 0 || tw.ToTime > 0
+}
+
+//Contains time is inside window
+func (tw TimeWindow) Contains(t time.Time) bool {
+	if tw.FromTime > 0 && t.Unix() < tw.FromTime {
+		return false
+	}
+	if tw.ToTime > 0 && t.Unix() > tw.ToTime {
+		return false
+	}
+	return true
 }
 
 //StatReq stats req
@@ -73,16 +206,329 @@ type HostDetails struct {
 	Logs              []string `json:"paths"`
 }
 
-//SearchRequest search request
+//AppRequest application and env, optionally narrowed to hosts with all given tags
+type AppRequest struct {
+	App  string   `json:"app"`
+	Env  string   `json:"env"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+//AppHosts hosts and log structure of application
+type AppHosts struct {
+	App          string              `json:"app"`
+	Env          string              `json:"env"`
+	Hosts        []HostDetails       `json:"hosts"`
+	LogStructure *model.LogStructure `json:"logStructure"`
+}
+
+//SearchRequest search request, hosts are resolved from config when app is set
 type SearchRequest struct {
-	Hosts []HostDetails `json:"hosts"`
-	Value string        `json:"value"`
+	AppRequest
+	Hosts        []HostDetails       `json:"hosts"`
+	Value        string              `json:"value"`
+	Query        string              `json:"query"`
+	LogStructure *model.LogStructure `json:"logStructure"`
+	TimeWindow
+	Before int `json:"before"`
+	After  int `json:"after"`
+	//MaxResults max matched lines returned by stream, 0 is unlimited
+	MaxResults int `json:"maxResults"`
+	//Events multi line events like stack traces are matched and returned as one line
+	Events bool `json:"events"`
+}
+
+//SearchBatch streamed search results of single host
+type SearchBatch struct {
+	Results []GrepResponse `json:"results"`
+	Status  HostStatus     `json:"status"`
 }
 
-//TailLogRequest tail log request
+//SearchTrailer last record of search stream
+type SearchTrailer struct {
+	Trailer   bool         `json:"trailer"`
+	Truncated bool         `json:"truncated"`
+	Matches   int          `json:"matches"`
+	Hosts     []HostStatus `json:"hosts"`
+}
+
+//GrepRequest agent grep request, lines must contain value and match query
+type GrepRequest struct {
+	model.GrepRequest
+	Query        string              `json:"query,omitempty"`
+	LogStructure *model.LogStructure `json:"logStructure,omitempty"`
+	TimeWindow
+	Before     int  `json:"before,omitempty"`
+	After      int  `json:"after,omitempty"`
+	MaxResults int  `json:"maxResults,omitempty"`
+	Events     bool `json:"events,omitempty"`
+}
+
+const (
+	//GrepEndpoint agent grep
+	GrepEndpoint = "grep"
+	//AgentStatsEndpoint agent stats
+	AgentStatsEndpoint = "agent-stats"
+	//AgentErrorsEndpoint agent errors
+	AgentErrorsEndpoint = "agent-errors"
+	//AgentDownloadEndpoint agent raw log download
+	AgentDownloadEndpoint = "agent-download"
+)
+
+//SearchResponse search results with per host status
+type SearchResponse struct {
+	Results []GrepResponse `json:"results"`
+	Hosts   []HostStatus   `json:"hosts"`
+}
+
+//GrepResponse matched lines with context blocks when requested
+type GrepResponse struct {
+	model.GrepResponse
+	Blocks []ContextBlock `json:"blocks,omitempty"`
+}
+
+//ContextBlock consecutive lines around matches, overlapping blocks are merged
+type ContextBlock struct {
+	//Start line number of first line
+	Start int      `json:"start"`
+	Lines []string `json:"lines"`
+	//Matches indexes of matched lines
+	Matches []int `json:"matches"`
+}
+
+//HostStatus status of a single host call
+type HostStatus struct {
+	Host     string `json:"host"`
+	Endpoint string `json:"endpoint"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Latency  int64  `json:"latency"`
+	Matches  int    `json:"matches"`
+}
+
+const (
+	//StatusOK host answered
+	StatusOK = "ok"
+	//StatusTimeout host did not answer in time
+	StatusTimeout = "timeout"
+	//StatusError host call failed
+	StatusError = "error"
+	//StatusPending host not answered yet
+	StatusPending = "pending"
+	//StatusSkipped host results not needed, result limit was reached
+	StatusSkipped = "skipped"
+)
+
+//JobProgress search job progress
+type JobProgress struct {
+	ID       string       `json:"id"`
+	Status   string       `json:"status"`
+	Hosts    []HostStatus `json:"hosts"`
+	Matches  int          `json:"matches"`
+	Started  int64        `json:"started"`
+	Finished int64        `json:"finished,omitempty"`
+	//Truncated job stopped at result limit, remaining hosts were skipped
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+//JobResults page of search job results
+type JobResults struct {
+	Results    []GrepResponse    `json:"results"`
+	Pagination *model.Pagination `json:"pagination"`
+}
+
+const (
+	//JobRunning job is running
+	JobRunning = "running"
+	//JobDone all hosts answered
+	JobDone = "done"
+	//JobCancelled job was cancelled
+	JobCancelled = "cancelled"
+)
+
+//TraceRequest request id trace across application hosts
+type TraceRequest struct {
+	AppRequest
+	ReqID string `json:"reqid"`
+}
+
+//TraceLine line of trace timeline
+type TraceLine struct {
+	Time    int64  `json:"time"`
+	Date    string `json:"date"`
+	Host    string `json:"host"`
+	LogFile string `json:"logfile"`
+	Line    string `json:"line"`
+}
+
+//TraceResponse trace timeline sorted by time
+type TraceResponse struct {
+	Lines []TraceLine  `json:"lines"`
+	Hosts []HostStatus `json:"hosts"`
+}
+
+//TailLogRequest tail log request, with cursor only lines appended after cursor are returned,
+//empty cursor returns last lines with cursor for next request
 type TailLogRequest struct {
-	LogViewerEndpoint string `json:"endpoint"`
-	Log               string `json:"log"`
+	LogViewerEndpoint string      `json:"endpoint"`
+	Log               string      `json:"log"`
+	Cursor            *TailCursor `json:"cursor,omitempty"`
+	//Filter is applied by agent, level, user and reqid need log structure
+	Filter       *TailFilter         `json:"filter,omitempty"`
+	LogStructure *model.LogStructure `json:"logStructure,omitempty"`
+}
+
+//AgentTailRequest agent tail, extends module log request with cursor and filter
+type AgentTailRequest struct {
+	model.LogRequest
+	Cursor       *TailCursor         `json:"cursor,omitempty"`
+	Filter       *TailFilter         `json:"filter,omitempty"`
+	LogStructure *model.LogStructure `json:"logStructure,omitempty"`
+}
+
+//TailFilter lines matching all set fields are returned, level is minimum level
+type TailFilter struct {
+	Regex string `json:"regex,omitempty"`
+	Level string `json:"level,omitempty"`
+	User  string `json:"user,omitempty"`
+	ReqID string `json:"reqid,omitempty"`
+}
+
+//TailFilterMessage changes filter of open tail websocket, empty filter returns all lines
+type TailFilterMessage struct {
+	Filter *TailFilter `json:"filter"`
+}
+
+//TailCursor position after last returned line, file is fingerprint of first bytes of log
+type TailCursor struct {
+	File   string `json:"file"`
+	Offset int64  `json:"offset"`
+}
+
+//TailResponse tail lines with cursor of next request
+type TailResponse struct {
+	model.TailLogResponse
+	Cursor *TailCursor `json:"cursor,omitempty"`
+	//Rotated log was replaced since cursor, rest of old file is returned first when found
+	Rotated bool `json:"rotated,omitempty"`
+	//Truncated log was truncated since cursor and is read from start
+	Truncated bool `json:"truncated,omitempty"`
+	//More lines are left after cursor
+	More bool `json:"more,omitempty"`
+	//Filtered filter was applied by agent
+	Filtered bool `json:"filtered,omitempty"`
+}
+
+//DownloadRequest log download, only matching lines or events are downloaded when value or query is set
+type DownloadRequest struct {
+	TailLogRequest
+	Value  string `json:"value"`
+	Query  string `json:"query"`
+	Events bool   `json:"events"`
+	Gzip   bool   `json:"gzip"`
+}
+
+//AgentDownloadRequest agent log download
+type AgentDownloadRequest struct {
+	Log  string `json:"log"`
+	Gzip bool   `json:"gzip,omitempty"`
+}
+
+//BundleRequest logs of all application hosts in one archive, rotated logs are added when time window is set
+type BundleRequest struct {
+	AppRequest
+	TimeWindow
+	//Format zip or tar.gz, zip when empty
+	Format string `json:"format"`
+}
+
+//BundleFile archive entry
+type BundleFile struct {
+	Host    string `json:"host"`
+	Path    string `json:"path"`
+	Entry   string `json:"entry"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	Error   string `json:"error,omitempty"`
+}
+
+//BundleManifest last entry of archive with files and status of every host
+type BundleManifest struct {
+	App     string       `json:"app"`
+	Env     string       `json:"env"`
+	Created int64        `json:"created"`
+	Window  TimeWindow   `json:"window"`
+	Files   []BundleFile `json:"files"`
+	Hosts   []HostStatus `json:"hosts"`
+}
+
+//EvidenceRequest support package of application, lines of request id or of time window
+type EvidenceRequest struct {
+	AppRequest
+	ReqID string `json:"reqid"`
+	TimeWindow
+	//Format zip or tar.gz, zip when empty
+	Format string `json:"format"`
+}
+
+//EvidenceManifest last entry of support package
+type EvidenceManifest struct {
+	App     string     `json:"app"`
+	Env     string     `json:"env"`
+	ReqID   string     `json:"reqid,omitempty"`
+	Created int64      `json:"created"`
+	Window  TimeWindow `json:"window"`
+	Lines   int        `json:"lines"`
+	//Truncated some host returned max lines
+	Truncated bool         `json:"truncated"`
+	Files     []BundleFile `json:"files"`
+	Hosts     []HostStatus `json:"hosts"`
+	Stats     []HostStatus `json:"stats"`
+	Errors    []string     `json:"errors,omitempty"`
+}
+
+//AppTailRequest first message of application tail websocket
+type AppTailRequest struct {
+	AppRequest
+	Filter *TailFilter `json:"filter,omitempty"`
+}
+
+//AppTailLine line of merged application tail
+type AppTailLine struct {
+	Time    int64  `json:"time"`
+	Host    string `json:"host"`
+	LogFile string `json:"logfile"`
+	Line    string `json:"line"`
+}
+
+//TailStatus state of single followed log, sent when it changes
+type TailStatus struct {
+	Host      string `json:"host"`
+	Endpoint  string `json:"endpoint"`
+	LogFile   string `json:"logfile"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Rotated   bool   `json:"rotated,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+//AppTailMessage message of application tail websocket, merged lines or status of followed log
+type AppTailMessage struct {
+	Lines  []AppTailLine `json:"lines,omitempty"`
+	Status *TailStatus   `json:"status,omitempty"`
+	Error  string        `json:"error,omitempty"`
+	//Filter applied filter, sent when filter changes
+	Filter *TailFilter `json:"filter,omitempty"`
+	//Dropped lines dropped for slow client before this message
+	Dropped int `json:"dropped,omitempty"`
+}
+
+//TailSession upstream tail shared by websocket clients
+type TailSession struct {
+	Host        string `json:"host"`
+	Endpoint    string `json:"endpoint"`
+	LogFile     string `json:"logfile"`
+	Subscribers int    `json:"subscribers"`
+	Filtered    bool   `json:"filtered"`
 }
 
 //StatsKey stats key
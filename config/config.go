@@ -6,10 +6,15 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
 
+	lvm "github.com/RomanLorens/logviewer-module/handler"
 	"github.com/RomanLorens/logviewer-module/model"
 	"github.com/RomanLorens/logviewer/common"
 	l "github.com/RomanLorens/logviewer/logger"
+	"github.com/RomanLorens/logviewer/tlsconfig"
 	"github.com/RomanLorens/rl-common/filter"
 )
 
@@ -20,6 +25,20 @@ type ServerConfig struct {
 	StaticFolder string
 	Cert         string
 	CertKey      string
+	//HostTimeout max time to wait for a single host
+	HostTimeout time.Duration
+	//MaxConcurrentHosts max number of hosts queried at once
+	MaxConcurrentHosts int
+	//JobHostTimeout max time to wait for a single host in search job
+	JobHostTimeout time.Duration
+	//SelfAliases host names this instance is reachable by, besides hostname
+	SelfAliases []string
+	//AgentTLS tls options for calls to other logviewer agents
+	AgentTLS *tlsconfig.Options
+	//ClientCA CA bundle verifying client certificates of aggregators calling agent api
+	ClientCA string
+	//ForwardHeaders incoming headers passed to agents
+	ForwardHeaders []string
 }
 
 //AppConfig app config
@@ -31,6 +50,8 @@ type AppConfig struct {
 	Env          string              `json:"env"`
 	LogStructure *model.LogStructure `json:"logStructure"`
 	SupportURLs  []SupportURL        `json:"supportUrls"`
+	//Users allowed to view logs of app, everyone when empty
+	Users []string `json:"users,omitempty"`
 }
 
 //Host host
@@ -38,6 +59,7 @@ type Host struct {
 	Paths    []string `json:"paths"`
 	Endpoint string   `json:"endpoint"`
 	AppHost  string   `json:"appHost"`
+	Tags     []string `json:"tags"`
 }
 
 //SupportURL support url
@@ -77,11 +99,37 @@ func init() {
 	cert := flag.String("cert", "", "https server cert")
 	certKey := flag.String("certKey", "", "https server cert key")
 	enableScheduler := flag.Bool("enableScheduler", false, "run scheduler on this instance")
+	hostTimeout := flag.Duration("hostTimeout", 30*time.Second, "max time to wait for a single host")
+	maxConcurrentHosts := flag.Int("maxConcurrentHosts", 8, "max number of hosts queried at once")
+	jobHostTimeout := flag.Duration("jobHostTimeout", 30*time.Minute, "max time to wait for a single host in search job")
+	selfAliases := flag.String("selfAliases", "", "comma separated host names of this instance, besides hostname")
+	agentCA := flag.String("agentCA", "", "CA bundle verifying agent certificates, system roots when empty")
+	agentCert := flag.String("agentCert", "", "client certificate for mutual tls with agents")
+	agentKey := flag.String("agentKey", "", "client certificate key for mutual tls with agents")
+	agentPins := flag.String("agentPins", "", "json file with pinned public keys per agent host")
+	insecure := flag.Bool("insecureSkipVerify", false, "do not verify agent certificates, never use in production")
+	clientCA := flag.String("clientCA", "", "CA bundle verifying client certificates on agent api")
+	forwardHeaders := flag.String("forwardHeaders", "x-citiportal-ssoid,x-citiportal-LoginID,x-citiportal-requestid,Authorization",
+		"comma separated incoming headers passed to agents")
 	flag.Parse()
 
 	if *cert != "" && *certKey == "" {
 		logger.Panicf(context.Background(), "Both cert and cert key must be set!")
 	}
+	if (*agentCert == "") != (*agentKey == "") {
+		logger.Panicf(context.Background(), "Both agent cert and agent key must be set!")
+	}
+	agentTLS := &tlsconfig.Options{CAFile: *agentCA, CertFile: *agentCert, KeyFile: *agentKey, Insecure: *insecure}
+	if *agentPins != "" {
+		pins, err := tlsconfig.LoadPins(*agentPins)
+		if err != nil {
+			logger.Panicf(context.Background(), "Could not load agent pins, %v", err)
+		}
+		agentTLS.Pins = pins
+	}
+	if *insecure {
+		logger.Error(context.Background(), "Agent certificates are NOT verified, insecureSkipVerify is set")
+	}
 
 	switch *cr {
 	case "static":
@@ -99,13 +147,25 @@ func init() {
 	}
 
 	_config.ServerConfiguration = &ServerConfig{Port: *port, Context: *appContext, StaticFolder: *staticFolder,
-		Cert: *cert, CertKey: *certKey}
+		Cert: *cert, CertKey: *certKey, HostTimeout: *hostTimeout, MaxConcurrentHosts: *maxConcurrentHosts,
+		JobHostTimeout: *jobHostTimeout, SelfAliases: splitList(*selfAliases), AgentTLS: agentTLS, ClientCA: *clientCA,
+		ForwardHeaders: splitList(*forwardHeaders)}
 	logger.Info(context.Background(), "Enable scheduler = %v", *enableScheduler)
 	_config.EnableScheduler = *enableScheduler
 
 	Config = _config
 }
 
+func splitList(s string) []string {
+	out := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 //Resolver config resolver
 type Resolver interface {
 	GetConfig(ctx context.Context) (*Configuration, error)
@@ -182,3 +242,183 @@ func (f FileConfigResolver) GetStatsKeys(ctx context.Context, date string) (map[
 func GetAppStats(ctx context.Context, req *common.StatReq) ([]common.Stats, error) {
 	return resolver.GetAppStats(ctx, req)
 }
+
+//AppHosts resolves application hosts and paths from config
+func AppHosts(ctx context.Context, req *common.AppRequest) (*common.AppHosts, error) {
+	app, err := FindApp(req.App, req.Env)
+	if err != nil {
+		return nil, err
+	}
+	out := &common.AppHosts{App: app.Application, Env: app.Env, LogStructure: app.LogStructure,
+		Hosts: make([]common.HostDetails, 0, len(app.Hosts))}
+	for _, h := range app.Hosts {
+		if !hasTags(h, req.Tags) {
+			continue
+		}
+		out.Hosts = append(out.Hosts, common.HostDetails{LogViewerEndpoint: h.Endpoint, Logs: h.Paths})
+	}
+	if len(out.Hosts) == 0 {
+		return nil, fmt.Errorf("No hosts for %v %v with tags %v", req.App, req.Env, req.Tags)
+	}
+	logger.Info(ctx, "Resolved %v hosts for %v %v", len(out.Hosts), req.App, req.Env)
+	return out, nil
+}
+
+//AppSettings config of application added to support packages
+func AppSettings(ctx context.Context, req *common.AppRequest) (interface{}, error) {
+	app, err := FindApp(req.App, req.Env)
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+//KnownEndpoint reports endpoint is logviewer of host of any configured app
+func KnownEndpoint(endpoint string) bool {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if endpoint == "" {
+		return false
+	}
+	for _, app := range Config.ApplicationsConfig {
+		for _, h := range app.Hosts {
+			if strings.EqualFold(strings.TrimSuffix(h.Endpoint, "/"), endpoint) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//FindApp config of application in env
+func FindApp(application string, env string) (*AppConfig, error) {
+	for i := range Config.ApplicationsConfig {
+		app := &Config.ApplicationsConfig[i]
+		if strings.EqualFold(app.Application, application) && strings.EqualFold(app.Env, env) {
+			return app, nil
+		}
+	}
+	return nil, fmt.Errorf("Missing config for %v %v", application, env)
+}
+
+//Authorize checks user may view app in env, log when passed must be in paths of app host with endpoint,
+//empty endpoint is this logviewer and may be any host of app. Admins may view every app
+func Authorize(user string, admin bool, application string, env string, endpoint string, log string) error {
+	if application == "" || env == "" {
+		return fmt.Errorf("Must pass app and env")
+	}
+	app, err := FindApp(application, env)
+	if err != nil {
+		return err
+	}
+	if !admin && len(app.Users) > 0 && !containsFold(app.Users, user) {
+		return fmt.Errorf("User '%v' is not allowed to view %v %v", user, application, env)
+	}
+	if log == "" || app.hasLog(endpoint, filepath.Clean(log)) {
+		return nil
+	}
+	return fmt.Errorf("Log %v is not log of %v %v", log, application, env)
+}
+
+//AuthorizeLogs checks user may view logs of hosts. Log of app restricted to other users is rejected unless user
+//may view another app with same log, logs which are not in any app stay open to everyone
+func AuthorizeLogs(user string, admin bool, hosts []common.HostDetails) error {
+	if admin {
+		return nil
+	}
+	for _, h := range hosts {
+		for _, log := range h.Logs {
+			if err := authorizeLog(user, h.LogViewerEndpoint, filepath.Clean(log)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func authorizeLog(user string, endpoint string, log string) error {
+	var denied *AppConfig
+	for i := range Config.ApplicationsConfig {
+		app := &Config.ApplicationsConfig[i]
+		if !app.hasLog(endpoint, log) {
+			continue
+		}
+		if len(app.Users) == 0 || containsFold(app.Users, user) {
+			return nil
+		}
+		denied = app
+	}
+	if denied != nil {
+		return fmt.Errorf("User '%v' is not allowed to view %v of %v %v", user, log, denied.Application, denied.Env)
+	}
+	return nil
+}
+
+//hasLog log is in paths of app host with endpoint, any host when endpoint is empty
+func (app *AppConfig) hasLog(endpoint string, log string) bool {
+	for _, h := range app.Hosts {
+		if endpoint != "" && !strings.EqualFold(strings.TrimSuffix(h.Endpoint, "/"), strings.TrimSuffix(endpoint, "/")) {
+			continue
+		}
+		for _, p := range h.Paths {
+			p = filepath.Clean(p)
+			if log == p || strings.HasPrefix(log, strings.TrimSuffix(p, "/")+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, v string) bool {
+	for _, s := range values {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+//HealthChecks health urls of applications from support url named health, relative url is checked on every app host
+func HealthChecks() []lvm.Health {
+	out := make([]lvm.Health, 0)
+	for _, app := range Config.ApplicationsConfig {
+		url := ""
+		for _, s := range app.SupportURLs {
+			if strings.EqualFold(s.Name, "health") {
+				url = s.URL
+				break
+			}
+		}
+		if url == "" {
+			continue
+		}
+		if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+			out = append(out, lvm.Health{Host: url, App: app.Application, Env: app.Env})
+			continue
+		}
+		for _, h := range app.Hosts {
+			if h.AppHost == "" {
+				continue
+			}
+			out = append(out, lvm.Health{Host: strings.TrimSuffix(h.AppHost, "/") + "/" + strings.TrimPrefix(url, "/"),
+				App: app.Application, Env: app.Env})
+		}
+	}
+	return out
+}
+
+func hasTags(h Host, tags []string) bool {
+	for _, t := range tags {
+		found := false
+		for _, ht := range h.Tags {
+			if strings.EqualFold(t, ht) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
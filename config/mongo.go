@@ -9,14 +9,13 @@ import (
 	"strings"
 	"time"
 
-	"crypto/tls"
-
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/RomanLorens/logviewer/common"
+	"github.com/RomanLorens/logviewer/tlsconfig"
 	"github.com/RomanLorens/rl-common/filter"
 )
 
@@ -30,34 +29,11 @@ type mongoCreds struct {
 	DB               string `json:"database"`
 	AppsCollection   string `json:"apps_collection"`
 	ConfigCollection string `json:"config_collection"`
+	//TLS tls options for non local mongo, certificates are verified unless insecureSkipVerify is set
+	TLS *tlsconfig.Options `json:"tls"`
 }
 
-type zeroSource struct{}
-
-func (zeroSource) Read(b []byte) (n int, err error) {
-	for i := range b {
-		b[i] = 0
-	}
-	return len(b), nil
-}
-
-type logWriter struct{}
-
-var (
-	//TLSConfigAllTrust trust all connections
-	TLSConfigAllTrust = &tls.Config{
-		KeyLogWriter:       &logWriter{},
-		Rand:               zeroSource{},
-		InsecureSkipVerify: true,
-	}
-	_db *mongo.Database
-)
-
-//Write logs message
-func (*logWriter) Write(p []byte) (n int, err error) {
-	logger.Info(context.Background(), fmt.Sprintf("mongo tls connection %v", string(p)))
-	return len(p), nil
-}
+var _db *mongo.Database
 
 //SaveStats save stats
 func (f MongoConfigResolver) SaveStats(ctx context.Context, stats *common.Stats) error {
@@ -207,10 +183,9 @@ func (f MongoConfigResolver) connectDB(ctx context.Context) (*mongo.Database, er
 	if er != nil {
 		return nil, er
 	}
-	clientOptions := options.Client().ApplyURI(mongoCfg.URI)
-	if !strings.Contains(mongoCfg.URI, "localhost") {
-		//TODO should use certs - this trusts all
-		clientOptions.SetTLSConfig(TLSConfigAllTrust)
+	clientOptions, err := mongoOptions(mongoCfg)
+	if err != nil {
+		return nil, err
 	}
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
@@ -220,15 +195,32 @@ func (f MongoConfigResolver) connectDB(ctx context.Context) (*mongo.Database, er
 	return _db, nil
 }
 
+func mongoOptions(mongoCfg *mongoCreds) (*options.ClientOptions, error) {
+	clientOptions := options.Client().ApplyURI(mongoCfg.URI)
+	if !strings.Contains(mongoCfg.URI, "localhost") {
+		cfg, err := tlsconfig.Client(mongoCfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("Could not create mongo tls config, %v", err)
+		}
+		if err := mongoCfg.TLS.CheckHosts(clientOptions.Hosts); err != nil {
+			return nil, fmt.Errorf("Could not create mongo tls config, %v", err)
+		}
+		if cfg.InsecureSkipVerify {
+			logger.Error(context.Background(), "Mongo certificates are NOT verified, insecureSkipVerify is set")
+		}
+		clientOptions.SetTLSConfig(cfg)
+	}
+	return clientOptions, nil
+}
+
 func (f MongoConfigResolver) doWithMongo(ctx context.Context, callback func(c *mongo.Client, creds *mongoCreds) (interface{}, error)) (interface{}, error) {
 	mongoCfg, er := creds(f.FilePath)
 	if er != nil {
 		return nil, er
 	}
-	clientOptions := options.Client().ApplyURI(mongoCfg.URI)
-	if !strings.Contains(mongoCfg.URI, "localhost") {
-		//TODO should use certs - this trusts all
-		clientOptions.SetTLSConfig(TLSConfigAllTrust)
+	clientOptions, err := mongoOptions(mongoCfg)
+	if err != nil {
+		return nil, err
 	}
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
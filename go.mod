@@ -1,16 +1,28 @@
 module github.com/RomanLorens/logviewer
 
-go 1.13
+go 1.17
 
 require (
 	github.com/RomanLorens/logger v0.1.5
 	github.com/RomanLorens/logviewer-module v1.0.0
 	github.com/RomanLorens/rl-common v0.1.3
-	github.com/golang/snappy v0.0.2 // indirect
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d
-	github.com/xdg/stringprep v1.0.0 // indirect
 	go.mongodb.org/mongo-driver v1.4.6
+)
+
+require (
+	github.com/aws/aws-sdk-go v1.34.28 // indirect
+	github.com/go-stack/stack v1.8.0 // indirect
+	github.com/golang/snappy v0.0.2 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/klauspost/compress v1.9.5 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/xdg/scram v0.0.0-20180814205039-7eeb5667e42c // indirect
+	github.com/xdg/stringprep v1.0.0 // indirect
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2 // indirect
 	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a // indirect
 	golang.org/x/text v0.3.5 // indirect
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
 )
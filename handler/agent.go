@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/RomanLorens/logviewer/agent"
+	"github.com/RomanLorens/logviewer/common"
+	"github.com/gorilla/mux"
+)
+
+func agentHandlers(r *mux.Router) {
+	registerAgent("/lvm/"+common.GrepEndpoint, agentGrep, r, http.MethodPost)
+	registerAgent("/lvm/"+common.AgentStatsEndpoint, agentStats, r, http.MethodPost)
+	registerAgent("/lvm/"+common.AgentErrorsEndpoint, agentErrors, r, http.MethodPost)
+	registerAgent("/lvm/"+common.AgentDownloadEndpoint, agentDownload, r, http.MethodPost)
+}
+
+func agentGrep(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req common.GrepRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse req body as grep req, %v", err)
+	}
+	return agent.Grep(r.Context(), &req)
+}
+
+func agentStats(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req common.AgentStatsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse req body as stats req, %v", err)
+	}
+	return agent.Stats(r.Context(), &req)
+}
+
+func agentErrors(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req common.AgentErrorsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse req body as errors req, %v", err)
+	}
+	if req.Group {
+		return agent.ErrorGroups(r.Context(), &req)
+	}
+	return agent.Errors(r.Context(), &req)
+}
+
+func agentDownload(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req common.AgentDownloadRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse req body as download req, %v", err)
+	}
+	return nil, agent.ServeLog(w, r, req.Log, req.Gzip)
+}
+
+func agentTail(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req common.AgentTailRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse req body as log req, %v", err)
+	}
+	f, err := agent.NewFilter(req.Filter, req.LogStructure)
+	if err != nil {
+		return nil, err
+	}
+	return agent.Tail(req.Log, req.Cursor, f)
+}
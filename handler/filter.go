@@ -1,12 +1,23 @@
 package handler
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	log "github.com/RomanLorens/logger/log"
+	"github.com/RomanLorens/logviewer/auth"
+	"github.com/RomanLorens/logviewer/common"
 	"github.com/RomanLorens/logviewer/config"
 	l "github.com/RomanLorens/logviewer/logger"
 	f "github.com/RomanLorens/rl-common/filter"
+	"github.com/RomanLorens/rl-common/hash"
 )
 
 //UserFilter auth by user or ip filter
@@ -14,11 +25,211 @@ type UserFilter struct{}
 
 var (
 	//IPFilterInstance ip filter
-	IPFilterInstance = f.NewBearerTokenIPFilter(l.L, config.Config.WhiteListIPs)
+	IPFilterInstance = &IPFilter{}
 	//UserFilterInstance user filter filter
 	UserFilterInstance = &UserFilter{}
+	//ClientCertFilterInstance client cert filter
+	ClientCertFilterInstance = &ClientCertFilter{}
+	//clientCA set on server start, reloaded config has no server configuration
+	clientCA string
+	//AppFilterInstance app permissions filter
+	AppFilterInstance = &AppFilter{}
+	//LogFilterInstance app permissions filter of http apis
+	LogFilterInstance = &LogFilter{}
+	//AgentFilterInstance log permissions filter of agent apis
+	AgentFilterInstance = &AgentFilter{}
+	//StreamAuthInterval how often filters of open websockets and event streams are checked again, so revoked
+	//tokens and permissions end them
+	StreamAuthInterval = time.Minute
 )
 
+//IPFilter bearer token or ip filter of current config, rebuilt after config is reloaded so revalidated
+//streams see removed tokens and ips
+type IPFilter struct {
+	mutex  sync.Mutex
+	cfg    *config.Configuration
+	filter *f.BearerTokenIPFilter
+}
+
+//DoFilter authorize by bearer token or ip whitelisted in current config
+func (ipf *IPFilter) DoFilter(r *http.Request) (bool, *http.Request) {
+	ipf.mutex.Lock()
+	if ipf.filter == nil || ipf.cfg != config.Config {
+		ipf.cfg = config.Config
+		ipf.filter = f.NewBearerTokenIPFilter(l.L, config.Config.WhiteListIPs)
+	}
+	filter := ipf.filter
+	ipf.mutex.Unlock()
+	return filter.DoFilter(r)
+}
+
+//AppFilter authorizes user to app and env params, log param must be log of app
+type AppFilter struct{}
+
+//DoFilter authorize by app permissions
+func (AppFilter) DoFilter(r *http.Request) (bool, *http.Request) {
+	user, admin := filterUser(r)
+	err := config.Authorize(user, admin, r.FormValue("app"), r.FormValue("env"), r.FormValue("endpoint"),
+		r.FormValue("log"))
+	if err != nil {
+		l.L.Error(r.Context(), "User '%v' not authorized to %v, %v", user, r.URL.Path, err)
+		return false, r
+	}
+	return true, r
+}
+
+//LogFilter authorizes user to app and env and to logs of hosts of http api, params are read from query of get
+//and from json body of post
+type LogFilter struct{}
+
+//logParams params of http apis naming app or logs
+type logParams struct {
+	common.AppRequest
+	common.HostDetails
+	Log   string               `json:"log"`
+	Files []string             `json:"logs"`
+	Hosts []common.HostDetails `json:"hosts"`
+}
+
+//DoFilter authorize by app permissions
+func (LogFilter) DoFilter(r *http.Request) (bool, *http.Request) {
+	user, admin := filterUser(r)
+	p, err := readLogParams(r)
+	if err == nil && (p.App != "" || p.Env != "") {
+		err = config.Authorize(user, admin, p.App, p.Env, "", "")
+	}
+	if err == nil {
+		hosts := append(p.Hosts, p.HostDetails)
+		if p.Log != "" {
+			hosts = append(hosts, common.HostDetails{LogViewerEndpoint: p.LogViewerEndpoint, Logs: []string{p.Log}})
+		}
+		hosts = append(hosts, common.HostDetails{LogViewerEndpoint: p.LogViewerEndpoint, Logs: p.Files})
+		err = config.AuthorizeLogs(user, admin, hosts)
+	}
+	if err != nil {
+		l.L.Error(r.Context(), "User '%v' not authorized to %v, %v", user, r.URL.Path, err)
+		return false, r
+	}
+	return true, r
+}
+
+//AgentFilter calls of other logviewers authenticated as service are trusted as they authorized user already, logs
+//of any other call are authorized as logs of http apis
+type AgentFilter struct{}
+
+//DoFilter authorize service call or by log permissions
+func (AgentFilter) DoFilter(r *http.Request) (bool, *http.Request) {
+	if serviceCall(r) {
+		return true, r
+	}
+	return LogFilterInstance.DoFilter(r)
+}
+
+//readLogParams body is read and restored for handler
+func readLogParams(r *http.Request) (*logParams, error) {
+	var p logParams
+	if r.Method == http.MethodGet {
+		p.App, p.Env = r.FormValue("app"), r.FormValue("env")
+		p.LogViewerEndpoint, p.Log = r.FormValue("endpoint"), r.FormValue("log")
+		return &p, nil
+	}
+	if r.Body == nil {
+		return &p, nil
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Could not read req body, %v", err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if len(bytes.TrimSpace(b)) == 0 {
+		return &p, nil
+	}
+	if err = json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("Could not parse req body, %v", err)
+	}
+	return &p, nil
+}
+
+//filterUser user of request and whether user is admin
+func filterUser(r *http.Request) (string, bool) {
+	user, _ := r.Context().Value(log.UserKey).(string)
+	admin := false
+	for _, role := range auth.UserWithRoles(r).Roles {
+		admin = admin || role == "admin"
+	}
+	return user, admin
+}
+
+//doFilters request of last filter, filters may add user to context
+func doFilters(filters []f.Filter, r *http.Request) (*http.Request, bool) {
+	for _, f := range filters {
+		ok, fr := f.DoFilter(r)
+		if !ok {
+			return r, false
+		}
+		r = fr
+	}
+	return r, true
+}
+
+//revalidate checks filters of original long lived request every StreamAuthInterval, context of filtered request
+//is canceled when they fail
+func revalidate(filters []f.Filter, orig *http.Request, r *http.Request) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(r.Context())
+	r = r.WithContext(ctx)
+	go func() {
+		ticker := time.NewTicker(StreamAuthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, ok := doFilters(filters, orig); !ok {
+					l.L.Error(ctx, "Closing %v, not authorized anymore", r.URL.Path)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return r, cancel
+}
+
+//serviceCall request of other logviewer authenticated by verified client certificate or whitelisted bearer token,
+//only such calls are trusted to name user on whose behalf they are made
+func serviceCall(r *http.Request) bool {
+	if clientCA != "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	bearer := r.Header.Get("Authorization")
+	if !strings.HasPrefix(bearer, "Bearer ") {
+		return false
+	}
+	t := strings.SplitN(strings.TrimPrefix(bearer, "Bearer "), "-", 2)
+	if len(t) != 2 {
+		return false
+	}
+	for _, wl := range config.Config.WhiteListIPs {
+		if wl.User == t[0] && wl.Token != "" && hash.Verfify(t[1], wl.Token) {
+			return true
+		}
+	}
+	return false
+}
+
+//ClientCertFilter requires verified client certificate when client CA is configured
+type ClientCertFilter struct{}
+
+//DoFilter authorize by verified client certificate
+func (ClientCertFilter) DoFilter(r *http.Request) (bool, *http.Request) {
+	if clientCA == "" {
+		return true, r
+	}
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0, r
+}
+
 //UserFilter authorize by user or whitelisted ips
 func (UserFilter) DoFilter(r *http.Request) (bool, *http.Request) {
 	user := r.Context().Value(log.UserKey)
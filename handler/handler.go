@@ -12,9 +12,11 @@ import (
 	"github.com/RomanLorens/logviewer/auth"
 	"github.com/RomanLorens/logviewer/common"
 	"github.com/RomanLorens/logviewer/config"
+	"github.com/RomanLorens/logviewer/httpclient"
 	l "github.com/RomanLorens/logviewer/logger"
 	"github.com/RomanLorens/logviewer/resolver"
 	"github.com/RomanLorens/logviewer/scheduler"
+	"github.com/RomanLorens/logviewer/tlsconfig"
 	"github.com/RomanLorens/logviewer/user"
 	f "github.com/RomanLorens/rl-common/filter"
 
@@ -45,22 +47,50 @@ func StartServer() {
 		Handler(http.StripPrefix("/iq-logviewer-ui/", http.FileServer(http.Dir(config.Config.ServerConfiguration.StaticFolder))))
 	logger.Info(context.Background(), "Registered /logviewer-ui/ with static folder %v ", config.Config.ServerConfiguration.StaticFolder)
 
+	resolver.HostTimeout = config.Config.ServerConfiguration.HostTimeout
+	resolver.MaxConcurrentHosts = config.Config.ServerConfiguration.MaxConcurrentHosts
+	resolver.JobHostTimeout = config.Config.ServerConfiguration.JobHostTimeout
+	resolver.AppResolver = config.AppHosts
+	resolver.AppConfig = config.AppSettings
+	resolver.HealthChecks = config.HealthChecks
+	resolver.SelfAliases = config.Config.ServerConfiguration.SelfAliases
+	resolver.ServerPort = config.Config.ServerConfiguration.Port
+	resolver.ForwardHeaders = config.Config.ServerConfiguration.ForwardHeaders
+	resolver.ServiceToken = config.Config.Bearer
+	resolver.KnownEndpoint = config.KnownEndpoint
+	clientCA = config.Config.ServerConfiguration.ClientCA
+	if err := httpclient.ConfigureTLS(config.Config.ServerConfiguration.AgentTLS); err != nil {
+		logger.Panicf(context.Background(), "Could not create agent tls config, %v", err)
+	}
+
 	register("/", root, r, http.MethodGet)
-	register("/"+model.SearchEndpoint, resolver.Search, r, http.MethodPost)
-	register("/"+model.ListLogsEndpoint, resolver.ListLogs, r, http.MethodPost)
-	register("/"+model.TailLogEndpoint, resolver.TailLog, r, http.MethodPost)
-	register("/"+model.StatsEndpoint, resolver.Stats, r, http.MethodPost)
-	register("/"+model.ErrorsEndpoint, resolver.Errors, r, http.MethodPost)
-	register("/"+model.DownloadLogEndpoint, resolver.DownloadLog, r, http.MethodPost)
-	register("/"+model.CollectStatsEndpoint, resolver.CollectStatsHandler, r, http.MethodPost)
-
-	register("/lvm/"+model.SearchEndpoint, lvm.Search, r, http.MethodPost)
-	register("/lvm/"+model.ListLogsEndpoint, lvm.ListLogs, r, http.MethodPost)
-	register("/lvm/"+model.TailLogEndpoint, lvm.TailLog, r, http.MethodPost)
-	register("/lvm/"+model.StatsEndpoint, lvm.Stats, r, http.MethodPost)
-	register("/lvm/"+model.ErrorsEndpoint, lvm.Errors, r, http.MethodPost)
-	register("/lvm/"+model.DownloadLogEndpoint, lvm.DownloadLog, r, http.MethodPost)
-	register("/lvm/"+model.CollectStatsEndpoint, lvm.CollectStats, r, http.MethodPost)
+	registerApp("/"+model.SearchEndpoint, resolver.Search, r, http.MethodPost)
+	registerApp("/search-hosts", resolver.SearchHosts, r, http.MethodPost)
+	registerApp("/"+model.ListLogsEndpoint, resolver.ListLogs, r, http.MethodPost)
+	registerApp("/"+model.TailLogEndpoint, resolver.TailLog, r, http.MethodPost)
+	registerApp("/"+model.StatsEndpoint, resolver.Stats, r, http.MethodPost)
+	registerApp("/"+model.ErrorsEndpoint, resolver.Errors, r, http.MethodPost)
+	registerApp("/"+model.DownloadLogEndpoint, resolver.DownloadLog, r, http.MethodGet, http.MethodPost)
+	registerApp("/"+model.CollectStatsEndpoint, resolver.CollectStatsHandler, r, http.MethodPost)
+	registerApp("/app-errors", resolver.AppErrors, r, http.MethodPost)
+	registerApp("/app-log-stats", resolver.AppStats, r, http.MethodPost)
+	registerApp("/trace", resolver.Trace, r, http.MethodPost)
+	registerApp("/search-stream", resolver.SearchStream, r, http.MethodPost)
+	registerApp("/search-jobs", resolver.SubmitSearchJob, r, http.MethodPost)
+	register("/search-jobs/status", resolver.SearchJobStatus, r, http.MethodGet)
+	register("/search-jobs/results", resolver.SearchJobResults, r, http.MethodGet)
+	register("/search-jobs/cancel", resolver.CancelSearchJob, r, http.MethodPost)
+	registerApp("/log-bundle", resolver.Bundle, r, http.MethodGet, http.MethodPost)
+	registerApp("/support-package", resolver.Evidence, r, http.MethodPost)
+
+	registerAgent("/lvm/"+model.SearchEndpoint, lvm.Search, r, http.MethodPost)
+	registerAgent("/lvm/"+model.ListLogsEndpoint, lvm.ListLogs, r, http.MethodPost)
+	registerAgent("/lvm/"+model.TailLogEndpoint, agentTail, r, http.MethodPost)
+	registerAgent("/lvm/"+model.StatsEndpoint, lvm.Stats, r, http.MethodPost)
+	registerAgent("/lvm/"+model.ErrorsEndpoint, lvm.Errors, r, http.MethodPost)
+	registerAgent("/lvm/"+model.DownloadLogEndpoint, lvm.DownloadLog, r, http.MethodPost)
+	registerAgent("/lvm/"+model.CollectStatsEndpoint, lvm.CollectStats, r, http.MethodPost)
+	agentHandlers(r)
 
 	register("/auth/current-user", currentUser, r, http.MethodGet)
 	register("/user-details", userDetailsHandler, r, http.MethodGet)
@@ -71,8 +101,13 @@ func StartServer() {
 
 	supportHandlers(r)
 
-	registerWS("/ws/apps-health", lvm.AppsHealth, r)
-	registerWS("/ws/tail-log", lvm.TailLogWS, r)
+	registerWS("/ws/apps-health", resolver.AppsHealthWS, r)
+	registerWSWithFilters("/ws/tail-log", []f.Filter{UserFilterInstance, AppFilterInstance}, resolver.TailLogWS, r)
+	registerWSWithFilters("/ws/host-tail", []f.Filter{UserFilterInstance, AppFilterInstance}, resolver.HostTailWS, r)
+	registerWSWithFilters("/ws/app-tail", []f.Filter{UserFilterInstance, AppFilterInstance}, resolver.AppTailWS, r)
+	//fallback for clients behind proxies blocking websocket upgrade
+	registerSSE("/sse/apps-health", []f.Filter{UserFilterInstance}, resolver.AppsHealthSSE, r)
+	registerSSE("/sse/tail-log", []f.Filter{UserFilterInstance, AppFilterInstance}, resolver.TailLogSSE, r)
 
 	if config.Config.EnableScheduler {
 		scheduler.InitScheduler()
@@ -93,6 +128,15 @@ func StartServer() {
 			},
 			*/
 		}
+		if clientCA != "" {
+			pool, err := tlsconfig.LoadCAs(clientCA)
+			if err != nil {
+				logger.Panicf(context.Background(), "Could not load client CA, %v", err)
+			}
+			//browsers have no client certificate, agent api requires it with ClientCertFilter
+			cfg.ClientCAs = pool
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		}
 		srv := &http.Server{
 			Addr:      fmt.Sprintf(":%v", config.Config.ServerConfiguration.Port),
 			TLSConfig: cfg,
@@ -102,6 +146,9 @@ func StartServer() {
 			logger.Error(context.Background(), "Error on server main thread, %v", err)
 		}
 	} else {
+		if clientCA != "" {
+			logger.Error(context.Background(), "Client CA %v is set but server runs plain http without cert, every agent api call will be rejected", clientCA)
+		}
 		logger.Info(context.Background(), "Starting server on %v port, context %v", config.Config.ServerConfiguration.Port, config.Config.ServerConfiguration.Context)
 		if err := http.ListenAndServe(fmt.Sprintf(":%v", config.Config.ServerConfiguration.Port), r); err != nil {
 			logger.Error(context.Background(), "Error on server main thread, %v", err)
@@ -180,6 +227,18 @@ func registerWithFilters(path string, filters []f.Filter, fn func(w http.Respons
 	_register(path, filters, fn, r, methods...)
 }
 
+//registerAgent agent api, called by other logviewers, logs of calls which are not service calls are authorized
+func registerAgent(path string, fn func(w http.ResponseWriter, r *http.Request) (interface{}, error),
+	r *mux.Router, methods ...string) {
+	_register(path, []f.Filter{ClientCertFilterInstance, UserFilterInstance, AgentFilterInstance}, fn, r, methods...)
+}
+
+//registerApp api reading logs, user must be allowed to view app and logs of request
+func registerApp(path string, fn func(w http.ResponseWriter, r *http.Request) (interface{}, error),
+	r *mux.Router, methods ...string) {
+	_register(path, []f.Filter{UserFilterInstance, LogFilterInstance}, fn, r, methods...)
+}
+
 func register(path string, fn func(w http.ResponseWriter, r *http.Request) (interface{}, error),
 	r *mux.Router, methods ...string) {
 	_register(path, []f.Filter{UserFilterInstance}, fn, r, methods...)
@@ -193,16 +252,17 @@ func _register(path string, filters []f.Filter, fn func(w http.ResponseWriter, r
 	}
 
 	h := func(w http.ResponseWriter, r *http.Request) {
-		if filters != nil {
-			for _, f := range filters {
-				ok, r := f.DoFilter(r)
-				if !ok {
-					errorResponse(fmt.Errorf("Unauthorized by filter"), w, r)
-					return
-				}
-			}
+		r, ok := doFilters(filters, r)
+		if !ok {
+			errorResponse(fmt.Errorf("Unauthorized by filter"), w, r)
+			return
+		}
+		sw := &startedWriter{ResponseWriter: w}
+		res, err := fn(sw, r)
+		if err != nil && sw.started {
+			logger.Error(r.Context(), "[%v] %v failed after response was started, %v", r.Method, r.URL.RequestURI(), err)
+			return
 		}
-		res, err := fn(w, r)
 		w.Header().Set("Content-Type", "application/json")
 		if err != nil {
 			errorResponse(err, w, r)
@@ -220,12 +280,25 @@ func _register(path string, filters []f.Filter, fn func(w http.ResponseWriter, r
 }
 
 func registerWS(path string, fn func(w http.ResponseWriter, r *http.Request) error,
+	r *mux.Router) {
+	registerWSWithFilters(path, []f.Filter{UserFilterInstance}, fn, r)
+}
+
+//registerWSWithFilters filters are checked before upgrade and again every StreamAuthInterval while socket is open
+func registerWSWithFilters(path string, filters []f.Filter, fn func(w http.ResponseWriter, r *http.Request) error,
 	r *mux.Router) {
 	endpoint := fmt.Sprintf("%s%s", config.Config.ServerConfiguration.Context, path)
 	if endpoint[0] != '/' {
 		endpoint = fmt.Sprintf("/%s", endpoint)
 	}
-	h := func(w http.ResponseWriter, r *http.Request) {
+	h := func(w http.ResponseWriter, orig *http.Request) {
+		r, ok := doFilters(filters, orig)
+		if !ok {
+			errorResponse(fmt.Errorf("Unauthorized by filter"), w, r)
+			return
+		}
+		r, cancel := revalidate(filters, orig, r)
+		defer cancel()
 		err := fn(w, r)
 		if err != nil {
 			logger.Error(r.Context(), err.Error())
@@ -235,6 +308,20 @@ func registerWS(path string, fn func(w http.ResponseWriter, r *http.Request) err
 	r.HandleFunc(endpoint, h)
 }
 
+//registerSSE server sent events stream, filters are checked again every StreamAuthInterval like for websockets
+func registerSSE(path string, filters []f.Filter, fn func(w http.ResponseWriter, r *http.Request) (interface{}, error),
+	r *mux.Router) {
+	_register(path, nil, func(w http.ResponseWriter, orig *http.Request) (interface{}, error) {
+		r, ok := doFilters(filters, orig)
+		if !ok {
+			return nil, fmt.Errorf("Unauthorized by filter")
+		}
+		r, cancel := revalidate(filters, orig, r)
+		defer cancel()
+		return fn(w, r)
+	}, r, http.MethodGet)
+}
+
 func errorResponse(err error, w http.ResponseWriter, r *http.Request) {
 	logger.Error(r.Context(), err.Error())
 	logger.Error(r.Context(), "[%v] %v failed", r.Method, r.URL.RequestURI())
@@ -249,6 +336,30 @@ func errorResponse(err error, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//startedWriter remembers status or body was written, error of streaming handler can't be sent after that
+type startedWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *startedWriter) WriteHeader(code int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *startedWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+//Flush streaming handlers flush each chunk
+func (w *startedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.started = true
+		f.Flush()
+	}
+}
+
 func loggingFilter(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r = setContext(w, r)
@@ -257,7 +368,7 @@ func loggingFilter(next http.Handler) http.Handler {
 }
 
 func setContext(w http.ResponseWriter, r *http.Request) *http.Request {
-	id := r.Header.Get("x-citiportal-requestid")
+	id := r.Header.Get(common.RequestIDHeader)
 	if len(id) == 0 {
 		if v, err := uuid.NewV4(); err == nil {
 			id = v.String()
@@ -268,6 +379,12 @@ func setContext(w http.ResponseWriter, r *http.Request) *http.Request {
 	ctx = context.WithValue(ctx, log.ReqID, id)
 	r = r.WithContext(ctx)
 	w.Header().Add("__req_id__", id)
-	logger.Info(r.Context(), "[%v] %v", r.Method, r.URL.RequestURI())
+	if ou := r.Header.Get(common.OriginalUserHeader); ou != "" && serviceCall(r) {
+		logger.Info(r.Context(), "[%v] %v on behalf of '%v'", r.Method, r.URL.RequestURI(), ou)
+	} else if ou != "" {
+		logger.Info(r.Context(), "[%v] %v with unverified original user '%v'", r.Method, r.URL.RequestURI(), ou)
+	} else {
+		logger.Info(r.Context(), "[%v] %v", r.Method, r.URL.RequestURI())
+	}
 	return r
 }
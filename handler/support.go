@@ -8,7 +8,9 @@ import (
 
 	"github.com/RomanLorens/logviewer-module/utils"
 	"github.com/RomanLorens/logviewer/config"
+	"github.com/RomanLorens/logviewer/httpclient"
 	"github.com/RomanLorens/logviewer/request"
+	"github.com/RomanLorens/logviewer/resolver"
 	f "github.com/RomanLorens/rl-common/filter"
 	"github.com/gorilla/mux"
 )
@@ -22,15 +24,27 @@ func supportHandlers(r *mux.Router) {
 	registerWithFilters("/support/stop-server", []f.Filter{IPFilterInstance}, stopServer, r, http.MethodGet)
 	registerWithFilters("/support/mem-diagnostics", []f.Filter{IPFilterInstance}, lvm.MemoryDiagnostics, r, http.MethodGet)
 	register("/support/proxy", lvm.ProxyHandler, r, http.MethodGet, http.MethodPost)
-	register("/support/version", version, r, http.MethodGet)
+	register("/support/circuit-breakers", circuitBreakers, r, http.MethodGet)
+	register("/support/tail-sessions", tailSessions, r, http.MethodGet)
+	//open for instance handshake between logviewers
+	registerWithFilters("/support/version", nil, version, r, http.MethodGet)
 }
 
 func version(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	m := make(map[string]interface{})
 	m["hostname"], _ = utils.Hostname()
+	m["instanceId"] = resolver.InstanceID
 	return m, nil
 }
 
+func circuitBreakers(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return httpclient.Breakers(), nil
+}
+
+func tailSessions(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return resolver.TailSessions(), nil
+}
+
 func printRequest(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	return request.Parse(r), nil
 }
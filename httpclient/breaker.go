@@ -0,0 +1,140 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	//BreakerClosed requests pass
+	BreakerClosed = "closed"
+	//BreakerOpen requests fail fast
+	BreakerOpen = "open"
+	//BreakerHalfOpen single trial request passes
+	BreakerHalfOpen = "half-open"
+)
+
+var (
+	//BreakerThreshold consecutive failures which open circuit
+	BreakerThreshold = 5
+	//BreakerCooldown how long circuit stays open before trial request
+	BreakerCooldown = 30 * time.Second
+	breakers        = &breakerStore{breakers: make(map[string]*breaker)}
+)
+
+//BreakerStatus circuit breaker state of endpoint
+type BreakerStatus struct {
+	Endpoint  string `json:"endpoint"`
+	State     string `json:"state"`
+	Failures  int    `json:"failures"`
+	OpenedAt  int64  `json:"openedAt,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+type breakerStore struct {
+	mutex    sync.Mutex
+	breakers map[string]*breaker
+}
+
+type breaker struct {
+	mutex     sync.Mutex
+	endpoint  string
+	state     string
+	failures  int
+	openedAt  time.Time
+	lastError string
+	trial     bool
+}
+
+//Breakers state of all endpoints called so far
+func Breakers() []BreakerStatus {
+	breakers.mutex.Lock()
+	out := make([]BreakerStatus, 0, len(breakers.breakers))
+	for _, b := range breakers.breakers {
+		out = append(out, b.status())
+	}
+	breakers.mutex.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Endpoint < out[j].Endpoint
+	})
+	return out
+}
+
+//breakerFor breaker per scheme and host of url
+func breakerFor(_url string) *breaker {
+	key := _url
+	if u, err := url.Parse(_url); err == nil {
+		key = u.Scheme + "://" + u.Host
+	}
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+	b, ok := breakers.breakers[key]
+	if !ok {
+		b = &breaker{endpoint: key, state: BreakerClosed}
+		breakers.breakers[key] = b
+	}
+	return b
+}
+
+//allow fails fast while circuit is open, after cooldown lets single trial request through
+func (b *breaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= BreakerCooldown {
+		b.state = BreakerHalfOpen
+	}
+	switch b.state {
+	case BreakerOpen:
+		return fmt.Errorf("Circuit open for %v, %v", b.endpoint, b.lastError)
+	case BreakerHalfOpen:
+		if b.trial {
+			return fmt.Errorf("Circuit half-open for %v, waiting for trial request", b.endpoint)
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+//record result of request, only unreachable or unavailable endpoint counts as failure
+func (b *breaker) record(ctx context.Context, failed bool, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.trial = false
+	if err == nil || !failed {
+		if b.state != BreakerClosed {
+			logger.Info(ctx, "Circuit closed for %v", b.endpoint)
+		}
+		b.state, b.failures, b.lastError = BreakerClosed, 0, ""
+		return
+	}
+	//cancelled by caller, says nothing about endpoint
+	if ctx.Err() == context.Canceled {
+		return
+	}
+	b.failures++
+	b.lastError = err.Error()
+	if b.state == BreakerHalfOpen || b.failures >= BreakerThreshold {
+		if b.state != BreakerOpen {
+			logger.Error(ctx, "Circuit opened for %v after %v failures, %v", b.endpoint, b.failures, err)
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *breaker) status() BreakerStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s := BreakerStatus{Endpoint: b.endpoint, State: b.state, Failures: b.failures, LastError: b.lastError}
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= BreakerCooldown {
+		s.State = BreakerHalfOpen
+	}
+	if !b.openedAt.IsZero() && s.State != BreakerClosed {
+		s.OpenedAt = b.openedAt.UnixNano() / int64(time.Millisecond)
+	}
+	return s
+}
@@ -6,36 +6,119 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	l "github.com/RomanLorens/logviewer/logger"
+	"github.com/RomanLorens/logviewer/tlsconfig"
 )
 
 var (
-	//trust all
-	client = &http.Client{Transport: &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}}
+	dialer    = &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	transport = &http.Transport{
+		TLSClientConfig:     &tls.Config{MinVersion: tls.VersionTLS12},
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	client = &http.Client{Transport: transport}
 	logger = l.L
+	//DefaultTimeout deadline for calls whose context has none
+	DefaultTimeout = 2 * time.Minute
+	//MaxRetries max retries of idempotent call
+	MaxRetries = 2
+	//RetryBackoff wait before first retry, doubled with every next one
+	RetryBackoff = 200 * time.Millisecond
 )
 
-//Request make req
+//ConfigureTLS tls for agent calls, set on server start
+func ConfigureTLS(o *tlsconfig.Options) error {
+	cfg, err := tlsconfig.Client(o)
+	if err != nil {
+		return err
+	}
+	transport.TLSClientConfig = cfg
+	transport.DialTLSContext = tlsconfig.DialTLS(o, cfg, dialer)
+	transport.CloseIdleConnections()
+	return nil
+}
+
+//Request make post req, not retried
 func Request(ctx context.Context, url string, post interface{}, headers http.Header) ([]byte, error) {
-	logger.Info(ctx, "Remote api for %v", url)
 	b, err := json.Marshal(post)
 	if err != nil {
 		logger.Error(ctx, "Could not marshal post %v", err)
 		return nil, fmt.Errorf("Could not marshal post %v", err)
 	}
+	return do(ctx, http.MethodPost, url, b, headers, 0)
+}
+
+//IdempotentRequest make post req which does not change agent state, retried with backoff on connection errors
+//and unavailable agent
+func IdempotentRequest(ctx context.Context, url string, post interface{}, headers http.Header) ([]byte, error) {
+	b, err := json.Marshal(post)
+	if err != nil {
+		logger.Error(ctx, "Could not marshal post %v", err)
+		return nil, fmt.Errorf("Could not marshal post %v", err)
+	}
+	return do(ctx, http.MethodPost, url, b, headers, MaxRetries)
+}
+
+//Get make get req, retried like idempotent request
+func Get(ctx context.Context, url string, headers http.Header) ([]byte, error) {
+	return do(ctx, http.MethodGet, url, nil, headers, MaxRetries)
+}
+
+func do(ctx context.Context, method string, url string, body []byte, headers http.Header, retries int) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+	b := breakerFor(url)
+	for attempt := 0; ; attempt++ {
+		if err := b.allow(); err != nil {
+			logger.Error(ctx, "Request to %v rejected, %v", url, err)
+			return nil, err
+		}
+		res, retry, err := send(ctx, method, url, body, headers)
+		b.record(ctx, retry, err)
+		if err == nil {
+			return res, nil
+		}
+		if !retry || attempt >= retries || ctx.Err() != nil {
+			return nil, err
+		}
+		wait := RetryBackoff << uint(attempt)
+		wait += time.Duration(rand.Int63n(int64(wait)/2 + 1))
+		logger.Info(ctx, "Retrying %v in %v, %v", url, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(b))
+//send single request, retry is true when agent could not be reached or is unavailable
+func send(ctx context.Context, method string, url string, body []byte, headers http.Header) ([]byte, bool, error) {
+	logger.Info(ctx, "Remote api for %v", url)
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
 	if err != nil {
 		logger.Error(ctx, "Could not create req for %v, %v", url, err)
-		return nil, fmt.Errorf("Could not create req for %v, %v", url, err)
+		return nil, false, fmt.Errorf("Could not create req for %v, %v", url, err)
+	}
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
 	}
-	req.Header.Add("Content-Type", "application/json")
 	for k, vals := range headers {
 		for _, v := range vals {
 			req.Header.Add(k, v)
@@ -44,19 +127,83 @@ func Request(ctx context.Context, url string, post interface{}, headers http.Hea
 	resp, err := client.Do(req)
 	if err != nil {
 		logger.Error(ctx, "Request to %v failed, %v", url, err)
-		return nil, fmt.Errorf("Request to %v failed, %v", url, err)
+		return nil, true, fmt.Errorf("Request to %v failed, %v", url, err)
 	}
 	defer resp.Body.Close()
 	logger.Info(ctx, "Api response %v", resp)
 	if resp.StatusCode != 200 {
 		b, _ := ioutil.ReadAll(resp.Body)
 		logger.Error(ctx, "Request to %v failed, %v", url, string(b))
-		bb, err := json.Marshal(post)
-		if err == nil {
-			logger.Error(ctx, "Request body '%v'", string(bb))
+		if body != nil {
+			logger.Error(ctx, "Request body '%v'", string(body))
 		}
-		return nil, fmt.Errorf("Request to %v failed, %v", url, string(b))
+		return nil, unavailable(resp.StatusCode), &StatusError{URL: url, Status: resp.StatusCode, Message: string(b)}
 	}
+	res, err := readBody(ctx, resp)
+	return res, err != nil, err
+}
+
+//Stream make post req returning response with unread body, caller must close it. Not retried,
+//2xx, 3xx and 416 responses are returned, others are errors
+func Stream(ctx context.Context, url string, post interface{}, headers http.Header) (*http.Response, error) {
+	b, err := json.Marshal(post)
+	if err != nil {
+		return nil, fmt.Errorf("Could not marshal post %v", err)
+	}
+	br := breakerFor(url)
+	if err = br.allow(); err != nil {
+		return nil, err
+	}
+	logger.Info(ctx, "Remote stream api for %v", url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		br.record(ctx, false, err)
+		return nil, fmt.Errorf("Could not create req for %v, %v", url, err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	for k, vals := range headers {
+		for _, v := range vals {
+			req.Header.Add(k, v)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		br.record(ctx, true, err)
+		return nil, fmt.Errorf("Request to %v failed, %v", url, err)
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		err = &StatusError{URL: url, Status: resp.StatusCode, Message: string(msg)}
+		br.record(ctx, unavailable(resp.StatusCode), err)
+		return nil, err
+	}
+	br.record(ctx, false, nil)
+	return resp, nil
+}
+
+//StatusError agent answered with error status
+type StatusError struct {
+	URL     string
+	Status  int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Request to %v failed, %v", e.URL, e.Message)
+}
+
+//NotFound error is not found status, agents which were not upgraded yet answer so for new endpoints
+func NotFound(err error) bool {
+	se, ok := err.(*StatusError)
+	return ok && se.Status == http.StatusNotFound
+}
+
+func unavailable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+func readBody(ctx context.Context, resp *http.Response) ([]byte, error) {
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		logger.Error(ctx, "Could not read response, %v", err)
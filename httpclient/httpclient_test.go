@@ -0,0 +1,78 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func agent(fail int32, status int) (*httptest.Server, *int32) {
+	var calls int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= fail {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`"ok"`))
+	})), &calls
+}
+
+func TestIdempotentRequestRetries(t *testing.T) {
+	defer func(b time.Duration, r int) { RetryBackoff, MaxRetries = b, r }(RetryBackoff, MaxRetries)
+	RetryBackoff, MaxRetries = time.Millisecond, 2
+	s, calls := agent(2, http.StatusServiceUnavailable)
+	defer s.Close()
+	res, err := IdempotentRequest(context.Background(), s.URL+"/lvm/grep", "req", nil)
+	if err != nil || string(res) != `"ok"` || *calls != 3 {
+		t.Fatalf("expected success after 3 calls, got %v %v after %v", string(res), err, *calls)
+	}
+
+	s, calls = agent(2, http.StatusServiceUnavailable)
+	defer s.Close()
+	if _, err = Request(context.Background(), s.URL+"/lvm/grep", "req", nil); err == nil || *calls != 1 {
+		t.Fatalf("non idempotent request should not be retried, %v calls", *calls)
+	}
+
+	s, calls = agent(1, http.StatusInternalServerError)
+	defer s.Close()
+	if _, err = IdempotentRequest(context.Background(), s.URL+"/lvm/grep", "req", nil); err == nil || *calls != 1 {
+		t.Fatalf("agent error should not be retried, %v calls", *calls)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	defer func(n int, c time.Duration) { BreakerThreshold, BreakerCooldown = n, c }(BreakerThreshold, BreakerCooldown)
+	BreakerThreshold, BreakerCooldown = 2, time.Hour
+	s, calls := agent(2, http.StatusBadGateway)
+	defer s.Close()
+	for i := 0; i < 2; i++ {
+		Request(context.Background(), s.URL+"/lvm/grep", "req", nil)
+	}
+	if _, err := Request(context.Background(), s.URL+"/lvm/stats", "req", nil); err == nil || *calls != 2 {
+		t.Fatalf("open circuit should fail fast, %v calls", *calls)
+	}
+	st := status(s.URL)
+	if st.State != BreakerOpen || st.Failures != 2 || st.OpenedAt == 0 {
+		t.Fatalf("wrong breaker status %+v", st)
+	}
+
+	BreakerCooldown = 0
+	if _, err := Request(context.Background(), s.URL+"/lvm/grep", "req", nil); err != nil {
+		t.Fatalf("trial request should pass, %v", err)
+	}
+	if st = status(s.URL); st.State != BreakerClosed || st.Failures != 0 {
+		t.Fatalf("circuit should close after trial, %+v", st)
+	}
+}
+
+func status(endpoint string) BreakerStatus {
+	for _, b := range Breakers() {
+		if b.Endpoint == endpoint {
+			return b
+		}
+	}
+	return BreakerStatus{}
+}
@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"strings"
+	"time"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer-module/search"
+)
+
+//Line log line split by log structure
+type Line struct {
+	Raw     string
+	Date    string
+	Time    time.Time
+	Level   string
+	User    string
+	ReqID   string
+	Message string
+}
+
+//Parse splits pipe delimited line by log structure, returns nil when line does not match structure
+func Parse(line string, ls *model.LogStructure) *Line {
+	if ls == nil {
+		return nil
+	}
+	tokens := strings.Split(line, "|")
+	max := maxColumn(ls)
+	if len(tokens) <= max {
+		return nil
+	}
+	l := &Line{
+		Raw:   line,
+		Date:  strings.TrimSpace(tokens[ls.Date]),
+		Level: strings.ToUpper(strings.TrimSpace(search.NormalizeText(tokens[ls.Level]))),
+		User:  strings.TrimSpace(tokens[ls.User]),
+		ReqID: strings.TrimSpace(tokens[ls.Reqid]),
+	}
+	if ls.Message == max {
+		//message may contain delimiter
+		l.Message = strings.Join(tokens[ls.Message:], "|")
+	} else {
+		l.Message = tokens[ls.Message]
+	}
+	if t, err := ParseTime(l.Date, ls.DateFormat); err == nil {
+		l.Time = t
+	}
+	return l
+}
+
+//ParseEvent parses multi line event, lines after first one are appended to message
+func ParseEvent(event string, ls *model.LogStructure) *Line {
+	i := strings.IndexByte(event, '\n')
+	if i < 0 {
+		return Parse(event, ls)
+	}
+	l := Parse(event[:i], ls)
+	if l == nil {
+		return nil
+	}
+	l.Raw = event
+	l.Message += event[i:]
+	return l
+}
+
+//StartsEvent line starts with date matching log structure
+func StartsEvent(line string, ls *model.LogStructure) bool {
+	tokens := strings.SplitN(line, "|", ls.Date+2)
+	if len(tokens) <= ls.Date {
+		return false
+	}
+	_, err := ParseTime(strings.TrimSpace(tokens[ls.Date]), ls.DateFormat)
+	return err == nil
+}
+
+//ParseTime parses date with go layout, date may be more precise than layout
+func ParseTime(date string, layout string) (time.Time, error) {
+	t, err := time.ParseInLocation(layout, date, time.Local)
+	if err != nil && len(date) > len(layout) {
+		return time.ParseInLocation(layout, date[:len(layout)], time.Local)
+	}
+	return t, err
+}
+
+//Millis unix time in milliseconds, 0 for zero time
+func Millis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func maxColumn(ls *model.LogStructure) int {
+	m := ls.Date
+	for _, c := range []int{ls.User, ls.Reqid, ls.Level, ls.Message} {
+		if c > m {
+			m = c
+		}
+	}
+	return m
+}
@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RomanLorens/logviewer-module/model"
+)
+
+var ls = &model.LogStructure{Date: 0, Level: 2, Message: 6, Reqid: 5, User: 4, DateFormat: "2006-01-02 15:04:05,000"}
+
+func TestParse(t *testing.T) {
+	l := Parse("2021-04-26 10:29:50,785|http-nio-9090-exec-1|ERROR|c.c.LogFilter|ab12345|1-01-CV@1-694984#6|/bcs/query 500|extra", ls)
+	if l == nil {
+		t.Fatal("should parse")
+	}
+	if l.Level != "ERROR" || l.User != "ab12345" || l.ReqID != "1-01-CV@1-694984#6" {
+		t.Fatalf("wrong columns %+v", l)
+	}
+	if l.Message != "/bcs/query 500|extra" {
+		t.Fatalf("wrong message '%v'", l.Message)
+	}
+	expected := time.Date(2021, 4, 26, 10, 29, 50, 785*int(time.Millisecond), time.Local)
+	if !l.Time.Equal(expected) {
+		t.Fatalf("wrong time %v", l.Time)
+	}
+	if Parse("\tat java.lang.Thread.run(Thread.java:748)", ls) != nil {
+		t.Fatal("stack trace line should not parse")
+	}
+}
+
+func TestParseTimePrefix(t *testing.T) {
+	d, err := ParseTime("2021-04-26 10:29:50,785", "2006-01-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Day() != 26 || d.Hour() != 0 {
+		t.Fatalf("wrong date %v", d)
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	event := "2021-04-26 10:29:50,785|exec-1|ERROR|c.c.Handler|ab12345|1-01@6|Request failed\n" +
+		"java.lang.NullPointerException\n\tat c.c.Handler.handle(Handler.java:42)"
+	l := ParseEvent(event, ls)
+	if l == nil || l.Raw != event {
+		t.Fatal("should parse event")
+	}
+	if l.Message != "Request failed\njava.lang.NullPointerException\n\tat c.c.Handler.handle(Handler.java:42)" {
+		t.Fatalf("wrong message '%v'", l.Message)
+	}
+	if !StartsEvent(event, ls) || StartsEvent("\tat c.c.Handler.handle(Handler.java:42)", ls) {
+		t.Fatal("wrong event start")
+	}
+}
@@ -0,0 +1,270 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/RomanLorens/logviewer/parser"
+)
+
+//Expr query expression
+type Expr interface {
+	Match(l *parser.Line) bool
+}
+
+type and struct{ left, right Expr }
+
+func (e and) Match(l *parser.Line) bool { return e.left.Match(l) && e.right.Match(l) }
+
+type or struct{ left, right Expr }
+
+func (e or) Match(l *parser.Line) bool { return e.left.Match(l) || e.right.Match(l) }
+
+type not struct{ expr Expr }
+
+func (e not) Match(l *parser.Line) bool { return !e.expr.Match(l) }
+
+type term struct {
+	field string
+	op    string
+	value string
+}
+
+func (t term) Match(l *parser.Line) bool {
+	var v string
+	switch t.field {
+	case "date":
+		v = l.Date
+	case "level":
+		v = l.Level
+	case "user":
+		v = l.User
+	case "reqid":
+		v = l.ReqID
+	case "message":
+		v = l.Message
+	case "line":
+		v = l.Raw
+	}
+	switch t.op {
+	case "=":
+		return strings.EqualFold(v, t.value)
+	case "!=":
+		return !strings.EqualFold(v, t.value)
+	default:
+		return strings.Contains(strings.ToLower(v), strings.ToLower(t.value))
+	}
+}
+
+var fields = map[string]bool{"date": true, "level": true, "user": true, "reqid": true, "message": true, "line": true}
+
+//Parse parses query like 'level=ERROR user=ab12345 message~"timeout"',
+//terms next to each other are joined with AND, supports AND, OR, NOT and parentheses
+func Parse(q string) (Expr, error) {
+	tokens, err := tokenize(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("Empty query")
+	}
+	p := &queryParser{tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("Unexpected '%v' in query", p.tokens[p.pos].text)
+	}
+	return e, nil
+}
+
+const (
+	tokenTerm = iota
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind int
+	text string
+	term term
+}
+
+func tokenize(q string) ([]token, error) {
+	out := make([]token, 0)
+	r := []rune(q)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			out = append(out, token{kind: tokenOpen, text: "("})
+			i++
+		case c == ')':
+			out = append(out, token{kind: tokenClose, text: ")"})
+			i++
+		default:
+			start := i
+			for i < len(r) && (unicode.IsLetter(r[i]) || r[i] == '_') {
+				i++
+			}
+			word := string(r[start:i])
+			if i < len(r) && (r[i] == '=' || r[i] == '~' || r[i] == '!') {
+				t, next, err := readTerm(r, word, i)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, token{kind: tokenTerm, text: string(r[start:next]), term: t})
+				i = next
+				continue
+			}
+			switch strings.ToUpper(word) {
+			case "AND":
+				out = append(out, token{kind: tokenAnd, text: word})
+			case "OR":
+				out = append(out, token{kind: tokenOr, text: word})
+			case "NOT":
+				out = append(out, token{kind: tokenNot, text: word})
+			default:
+				if word == "" {
+					word = string(c)
+				}
+				return nil, fmt.Errorf("Unexpected '%v' in query, expected field=value", word)
+			}
+		}
+	}
+	return out, nil
+}
+
+func readTerm(r []rune, field string, i int) (term, int, error) {
+	t := term{field: strings.ToLower(field)}
+	if !fields[t.field] {
+		return t, i, fmt.Errorf("Unknown field '%v', expected one of date, level, user, reqid, message, line", field)
+	}
+	switch {
+	case r[i] == '!' && i+1 < len(r) && r[i+1] == '=':
+		t.op = "!="
+		i += 2
+	case r[i] == '=' || r[i] == '~':
+		t.op = string(r[i])
+		i++
+	default:
+		return t, i, fmt.Errorf("Unknown operator after '%v'", field)
+	}
+	if i < len(r) && r[i] == '"' {
+		var sb strings.Builder
+		for i++; i < len(r); i++ {
+			if r[i] == '\\' && i+1 < len(r) {
+				i++
+				sb.WriteRune(r[i])
+				continue
+			}
+			if r[i] == '"' {
+				t.value = sb.String()
+				return t, i + 1, nil
+			}
+			sb.WriteRune(r[i])
+		}
+		return t, i, fmt.Errorf("Missing closing quote for '%v'", field)
+	}
+	start := i
+	for i < len(r) && !unicode.IsSpace(r[i]) && r[i] != ')' && r[i] != '(' {
+		i++
+	}
+	t.value = string(r[start:i])
+	if t.value == "" {
+		return t, i, fmt.Errorf("Missing value for '%v'", field)
+	}
+	return t, i, nil
+}
+
+type queryParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *queryParser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *queryParser) or() (Expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t != nil && t.kind == tokenOr; t = p.peek() {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = or{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) and() (Expr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t != nil && t.kind != tokenOr && t.kind != tokenClose; t = p.peek() {
+		if t.kind == tokenAnd {
+			p.pos++
+		}
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = and{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) not() (Expr, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, fmt.Errorf("Unexpected end of query")
+	}
+	if t.kind == tokenNot {
+		p.pos++
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return not{e}, nil
+	}
+	return p.primary()
+}
+
+func (p *queryParser) primary() (Expr, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, fmt.Errorf("Unexpected end of query")
+	}
+	p.pos++
+	switch t.kind {
+	case tokenTerm:
+		return t.term, nil
+	case tokenOpen:
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.peek(); c == nil || c.kind != tokenClose {
+			return nil, fmt.Errorf("Missing closing parenthesis")
+		}
+		p.pos++
+		return e, nil
+	}
+	return nil, fmt.Errorf("Unexpected '%v' in query", t.text)
+}
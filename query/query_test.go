@@ -0,0 +1,40 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/RomanLorens/logviewer/parser"
+)
+
+var line = &parser.Line{Level: "ERROR", User: "ab12345", ReqID: "1-01@6", Message: "Read timeout on /query"}
+
+func TestMatch(t *testing.T) {
+	queries := map[string]bool{
+		`level=ERROR user=ab12345 message~"timeout"`:   true,
+		`level=error AND message~TIMEOUT`:              true,
+		`level=INFO OR user=ab12345`:                   true,
+		`level=INFO OR user=bc23456`:                   false,
+		`NOT level=ERROR`:                              false,
+		`level!=INFO`:                                  true,
+		`(level=INFO OR level=ERROR) NOT user=ab12345`: false,
+		`message~"on /query"`:                          true,
+		`reqid="1-01@6"`:                               true,
+	}
+	for q, expected := range queries {
+		e, err := Parse(q)
+		if err != nil {
+			t.Fatalf("%v failed, %v", q, err)
+		}
+		if e.Match(line) != expected {
+			t.Fatalf("%v should be %v", q, expected)
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, q := range []string{"", "timeout", "host=a1", `message~"timeout`, "(level=ERROR", "level=ERROR AND", "level="} {
+		if _, err := Parse(q); err == nil {
+			t.Fatalf("'%v' should fail", q)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/agent"
+	"github.com/RomanLorens/logviewer/common"
+	"github.com/RomanLorens/logviewer/query"
+)
+
+//AppResolver resolves application hosts, set on server start
+var AppResolver func(ctx context.Context, req *common.AppRequest) (*common.AppHosts, error)
+
+func appHosts(ctx context.Context, req *common.AppRequest) (*common.AppHosts, error) {
+	if AppResolver == nil {
+		return nil, fmt.Errorf("Application resolver not configured")
+	}
+	if req.App == "" || req.Env == "" {
+		return nil, fmt.Errorf("Must pass app and env")
+	}
+	return AppResolver(ctx, req)
+}
+
+//searchHosts resolves hosts and validates grep request sent to every host
+func searchHosts(ctx context.Context, req *common.SearchRequest) ([]common.HostDetails, *common.GrepRequest, error) {
+	hosts := req.Hosts
+	gr := &common.GrepRequest{GrepRequest: model.GrepRequest{Value: req.Value}, Query: req.Query,
+		LogStructure: req.LogStructure, TimeWindow: req.TimeWindow, Before: req.Before, After: req.After,
+		Events: req.Events}
+	if req.App != "" {
+		app, err := appHosts(ctx, &req.AppRequest)
+		if err != nil {
+			return nil, nil, err
+		}
+		hosts = app.Hosts
+		if gr.LogStructure == nil {
+			gr.LogStructure = app.LogStructure
+		}
+	}
+	if (req.Query != "" || req.IsSet() || req.Events) && gr.LogStructure == nil {
+		return nil, nil, fmt.Errorf("Must pass log structure for query, time window or events")
+	}
+	if req.Before < 0 || req.After < 0 || req.Before > agent.MaxContextLines || req.After > agent.MaxContextLines {
+		return nil, nil, fmt.Errorf("Context lines must be between 0 and %v", agent.MaxContextLines)
+	}
+	if req.Query != "" {
+		if _, err := query.Parse(req.Query); err != nil {
+			return nil, nil, err
+		}
+	}
+	return hosts, gr, nil
+}
@@ -0,0 +1,394 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+	"github.com/RomanLorens/logviewer/parser"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	//TailInterval poll interval of every followed log
+	TailInterval = 2 * time.Second
+	//MergeDelay lines are held back so lines of slower hosts are sorted in by time
+	MergeDelay = time.Second
+	//TailRetryMax max wait between reconnects to dropped host
+	TailRetryMax = 30 * time.Second
+	//LegacyTailInterval how often module tail log websocket sends tail of log
+	LegacyTailInterval = 5 * time.Second
+	upgrader           = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+)
+
+//tailEvent lines, status change of followed log, applied filter or error, dropped lines before this event
+type tailEvent struct {
+	lines   []common.AppTailLine
+	status  *common.TailStatus
+	filter  *common.TailFilter
+	err     string
+	dropped int
+	//cursor of log after lines
+	cursor *common.TailCursor
+}
+
+//sharedFilter upstream filter of shared tail, read by follower before each poll
+type sharedFilter struct {
+	mutex  sync.Mutex
+	filter *common.TailFilter
+}
+
+func (f *sharedFilter) get() *common.TailFilter {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.filter
+}
+
+func (f *sharedFilter) set(filter *common.TailFilter) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.filter = filter
+}
+
+//AppTailWS follows every host and log of application, lines are merged in near timestamp order and tagged with host,
+//dropped hosts are reported and reconnected with cursor so no lines are lost
+func AppTailWS(w http.ResponseWriter, r *http.Request) error {
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return fmt.Errorf("Could not create websocket, %v", err)
+	}
+	defer c.Close()
+	var req common.AppTailRequest
+	if err := c.ReadJSON(&req); err != nil {
+		return fmt.Errorf("Could not parse incoming request, %v", err)
+	}
+	if err := authorized(r, map[string]string{"app": req.App, "env": req.Env}); err != nil {
+		return wsError(c, err)
+	}
+	app, err := appHosts(r.Context(), &req.AppRequest)
+	if err != nil {
+		return wsError(c, err)
+	}
+	return tailWS(r, c, app.Hosts, app.LogStructure, req.Filter)
+}
+
+//HostTailWS follows single log of host with optional filter, empty endpoint is this logviewer, messages are same
+//as of app tail. Module tail log websocket keeps serving whole tail window for existing clients
+func HostTailWS(w http.ResponseWriter, r *http.Request) error {
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return fmt.Errorf("Could not create websocket, %v", err)
+	}
+	defer c.Close()
+	var req common.TailLogRequest
+	if err := c.ReadJSON(&req); err != nil {
+		return fmt.Errorf("Could not parse incoming request, %v", err)
+	}
+	if req.Log == "" {
+		return wsError(c, fmt.Errorf("Must pass log"))
+	}
+	if err := authorized(r, map[string]string{"endpoint": req.LogViewerEndpoint, "log": req.Log}); err != nil {
+		return wsError(c, err)
+	}
+	hosts := []common.HostDetails{{LogViewerEndpoint: req.LogViewerEndpoint, Logs: []string{req.Log}}}
+	return tailWS(r, c, hosts, req.LogStructure, req.Filter)
+}
+
+//TailLogWS module tail log websocket, whole tail window of local log is sent on every LegacyTailInterval. Log of
+//request message must be log of upgrade params authorized by filters
+func TailLogWS(w http.ResponseWriter, r *http.Request) error {
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return fmt.Errorf("Could not create websocket, %v", err)
+	}
+	defer c.Close()
+	var req model.LogRequest
+	if err := c.ReadJSON(&req); err != nil {
+		return fmt.Errorf("Could not parse incoming request, %v", err)
+	}
+	if req.Log == "" {
+		err = fmt.Errorf("Must pass log")
+	} else {
+		err = authorized(r, map[string]string{"log": req.Log})
+	}
+	if err != nil {
+		//module clients know only tail responses, error is sent as close reason
+		c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()),
+			time.Now().Add(time.Second))
+		return err
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				logger.Info(ctx, "Closing tail - %v", err)
+				return
+			}
+		}
+	}()
+	ticker := time.NewTicker(LegacyTailInterval)
+	defer ticker.Stop()
+	for {
+		res, err := lapi.TailLog(ctx, req.Log)
+		if err != nil {
+			closeWS(c)
+			return fmt.Errorf("Could not tail %v, %v", req.Log, err)
+		}
+		if err := c.WriteJSON(res); err != nil {
+			return fmt.Errorf("Could not send tail of %v, %v", req.Log, err)
+		}
+		select {
+		case <-ctx.Done():
+			closeWS(c)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+//authorized request message must be for app, env, endpoint and log of upgrade params authorized by filters
+func authorized(r *http.Request, values map[string]string) error {
+	if r.FormValue("app") == "" {
+		return nil
+	}
+	for k, v := range values {
+		p := r.FormValue(k)
+		if k == "log" && filepath.Clean(p) == filepath.Clean(v) {
+			continue
+		}
+		if k == "log" || !strings.EqualFold(strings.TrimSuffix(p, "/"), strings.TrimSuffix(v, "/")) {
+			return fmt.Errorf("Request %v '%v' does not match authorized '%v'", k, v, r.FormValue(k))
+		}
+	}
+	return nil
+}
+
+func wsError(c *websocket.Conn, err error) error {
+	c.WriteJSON(&common.AppTailMessage{Error: err.Error()})
+	closeWS(c)
+	return err
+}
+
+//tailWS subscribes to shared tails of logs of hosts until socket is closed, filter messages change filter of client
+func tailWS(r *http.Request, c *websocket.Conn, hosts []common.HostDetails, ls *model.LogStructure,
+	filter *common.TailFilter) error {
+	client, err := newTailClient(filter, ls)
+	if err != nil {
+		return wsError(c, err)
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	subs := make([]*tailSession, 0)
+	defer func() {
+		for _, ts := range subs {
+			sessions.unsubscribe(ctx, ts, client)
+		}
+	}()
+	for _, h := range hosts {
+		for _, log := range h.Logs {
+			ts, err := sessions.subscribe(ctx, h.LogViewerEndpoint, log, ls, client)
+			if err != nil {
+				return wsError(c, err)
+			}
+			subs = append(subs, ts)
+		}
+	}
+	go readFilters(ctx, cancel, c, client, subs)
+	return mergeTail(ctx, c, client.out)
+}
+
+//readFilters applies filter messages until socket is closed, invalid filter is reported and old one is kept
+func readFilters(ctx context.Context, cancel context.CancelFunc, c *websocket.Conn, client *tailClient,
+	subs []*tailSession) {
+	defer cancel()
+	for {
+		_, b, err := c.ReadMessage()
+		if err != nil {
+			logger.Info(ctx, "Closing tail - %v", err)
+			return
+		}
+		var m common.TailFilterMessage
+		e := tailEvent{}
+		if err = json.Unmarshal(b, &m); err != nil {
+			e.err = fmt.Sprintf("Could not parse filter message, %v", err)
+		} else if err = client.setFilter(m.Filter); err != nil {
+			e.err = err.Error()
+		} else {
+			for _, ts := range subs {
+				ts.filterChanged()
+			}
+			e.filter = m.Filter
+			if e.filter == nil {
+				e.filter = &common.TailFilter{}
+			}
+			logger.Info(ctx, "Tail filter changed to %+v", *e.filter)
+		}
+		client.reply(ctx, e)
+	}
+}
+
+//follow polls log with cursor, failed polls are retried with growing delay from last cursor
+func follow(ctx context.Context, endpoint string, log string, ls *model.LogStructure, filter *sharedFilter,
+	headers http.Header, out chan<- tailEvent) {
+	st := common.TailStatus{Host: tailHost(ctx, endpoint), Endpoint: endpoint, LogFile: log}
+	cursor := &common.TailCursor{}
+	var last time.Time
+	delay := TailInterval
+	send := func(e tailEvent) bool {
+		select {
+		case out <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for {
+		pctx, cancel := context.WithTimeout(ctx, HostTimeout)
+		req := &common.TailLogRequest{Log: log, Cursor: cursor, Filter: filter.get(), LogStructure: ls}
+		res, err := hostTail(pctx, endpoint, req, headers)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			if st.Status != common.StatusError {
+				logger.Error(ctx, "Lost tail of %v on %v, %v", log, endpoint, err)
+				st.Status, st.Error = common.StatusError, err.Error()
+				s := st
+				if !send(tailEvent{status: &s}) {
+					return
+				}
+			}
+			delay *= 2
+			if delay > TailRetryMax {
+				delay = TailRetryMax
+			}
+		} else {
+			if st.Status != common.StatusOK || res.Rotated || res.Truncated {
+				st.Status, st.Error, st.Rotated, st.Truncated = common.StatusOK, "", res.Rotated, res.Truncated
+				s := st
+				if !send(tailEvent{status: &s}) {
+					return
+				}
+			}
+			cursor = res.Cursor
+			lines := tailLines(res, st.Host, log, ls, &last)
+			if len(lines) > 0 && !send(tailEvent{lines: lines, cursor: cursor}) {
+				return
+			}
+			delay = TailInterval
+			if res.More {
+				delay = 0
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func tailHost(ctx context.Context, endpoint string) string {
+	if endpoint == "" {
+		return getHostname()
+	}
+	return parseHostName(ctx, endpoint)
+}
+
+//tailLines tags lines with host and time, lines without date keep time of previous line
+func tailLines(res *common.TailResponse, host string, log string, ls *model.LogStructure,
+	last *time.Time) []common.AppTailLine {
+	lines := make([]common.AppTailLine, 0, len(res.Lines))
+	for _, line := range res.Lines {
+		if l := parser.Parse(line, ls); l != nil && !l.Time.IsZero() {
+			*last = l.Time
+		}
+		lines = append(lines, common.AppTailLine{Time: parser.Millis(*last), Host: host, LogFile: log, Line: line})
+	}
+	return lines
+}
+
+type pendingLine struct {
+	line    common.AppTailLine
+	arrived time.Time
+}
+
+//mergeTail writes status changes at once, lines are written after merge delay sorted by time
+func mergeTail(ctx context.Context, c *websocket.Conn, events <-chan tailEvent) error {
+	ticker := time.NewTicker(MergeDelay / 2)
+	defer ticker.Stop()
+	pending := make([]pendingLine, 0)
+	for {
+		select {
+		case <-ctx.Done():
+			closeWS(c)
+			return nil
+		case e := <-events:
+			if e.dropped > 0 {
+				//lines before gap are flushed so marker stays where lines were dropped
+				var ready []common.AppTailLine
+				ready, pending = readyLines(pending, time.Now())
+				if len(ready) > 0 {
+					if err := c.WriteJSON(&common.AppTailMessage{Lines: ready}); err != nil {
+						return fmt.Errorf("Could not write to websocket, %v", err)
+					}
+				}
+				if err := c.WriteJSON(&common.AppTailMessage{Dropped: e.dropped}); err != nil {
+					return fmt.Errorf("Could not write to websocket, %v", err)
+				}
+			}
+			if e.lines == nil {
+				if err := c.WriteJSON(&common.AppTailMessage{Status: e.status, Filter: e.filter, Error: e.err}); err != nil {
+					return fmt.Errorf("Could not write to websocket, %v", err)
+				}
+				continue
+			}
+			now := time.Now()
+			for _, l := range e.lines {
+				pending = append(pending, pendingLine{line: l, arrived: now})
+			}
+		case now := <-ticker.C:
+			var ready []common.AppTailLine
+			ready, pending = readyLines(pending, now.Add(-MergeDelay))
+			if len(ready) == 0 {
+				continue
+			}
+			if err := c.WriteJSON(&common.AppTailMessage{Lines: ready}); err != nil {
+				return fmt.Errorf("Could not write to websocket, %v", err)
+			}
+		}
+	}
+}
+
+//readyLines lines arrived before deadline sorted by time, lines of same time keep arrival order
+func readyLines(pending []pendingLine, deadline time.Time) ([]common.AppTailLine, []pendingLine) {
+	ready := make([]common.AppTailLine, 0)
+	rest := pending[:0]
+	for _, p := range pending {
+		if p.arrived.After(deadline) {
+			rest = append(rest, p)
+			continue
+		}
+		ready = append(ready, p.line)
+	}
+	sort.SliceStable(ready, func(i, j int) bool {
+		return ready[i].Time < ready[j].Time
+	})
+	return ready, rest
+}
+
+func closeWS(c *websocket.Conn) {
+	c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+}
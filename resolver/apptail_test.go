@@ -0,0 +1,255 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/agent"
+	"github.com/RomanLorens/logviewer/common"
+	"github.com/gorilla/websocket"
+)
+
+func TestAppTailWS(t *testing.T) {
+	defer func(i, d, m time.Duration) { TailInterval, MergeDelay, TailRetryMax = i, d, m }(TailInterval, MergeDelay, TailRetryMax)
+	TailInterval, MergeDelay, TailRetryMax = 10*time.Millisecond, 20*time.Millisecond, 40*time.Millisecond
+	defer func(t string) { ServiceToken = t }(ServiceToken)
+	ServiceToken = "svc-token"
+	dir, err := ioutil.TempDir("", "apptail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local, remote := filepath.Join(dir, "local.log"), filepath.Join(dir, "remote.log")
+	ioutil.WriteFile(local, []byte("2021-05-06 11:27:02,000|main|INFO|c.App|ab12345|r1|local first\n"), 0644)
+	ioutil.WriteFile(remote, []byte("2021-05-06 11:27:01,000|main|INFO|c.App|ab12345|r2|remote first\n"), 0644)
+
+	var failures int32 = 2
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req common.AgentTailRequest
+		if r.URL.Path != "/iq-logviewer/lvm/"+model.TailLogEndpoint || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		res, _ := agent.Tail(req.Log, req.Cursor, nil)
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer peer.Close()
+	AppResolver = func(ctx context.Context, req *common.AppRequest) (*common.AppHosts, error) {
+		return &common.AppHosts{App: req.App, Env: req.Env, LogStructure: lsTime,
+			Hosts: []common.HostDetails{{LogViewerEndpoint: localLVMEndpoint, Logs: []string{local}},
+				{LogViewerEndpoint: peer.URL + "/iq-logviewer/lvm", Logs: []string{remote}}}}, nil
+	}
+	defer func() { AppResolver = nil }()
+
+	done := make(chan bool)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AppTailWS(w, r)
+		close(done)
+	}))
+	defer s.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		c.Close()
+		<-done
+	}()
+	if err := c.WriteJSON(&common.AppTailRequest{AppRequest: common.AppRequest{App: "app", Env: "sit"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	remoteHost := parseHostName(context.Background(), peer.URL)
+	statuses := make([]string, 0)
+	lines := make([]common.AppTailLine, 0)
+	appended := false
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(lines) < 3 {
+		var m common.AppTailMessage
+		if err := c.ReadJSON(&m); err != nil {
+			t.Fatalf("%v, statuses %v, lines %+v", err, statuses, lines)
+		}
+		if m.Status != nil && m.Status.Host == remoteHost {
+			statuses = append(statuses, m.Status.Status)
+		}
+		lines = append(lines, m.Lines...)
+		if len(lines) == 2 && !appended {
+			f, _ := os.OpenFile(local, os.O_APPEND|os.O_WRONLY, 0644)
+			f.WriteString("2021-05-06 11:27:03,000|main|INFO|c.App|ab12345|r1|local second\n")
+			f.Close()
+			appended = true
+		}
+	}
+	if len(statuses) != 2 || statuses[0] != common.StatusError || statuses[1] != common.StatusOK {
+		t.Fatalf("remote host should drop out and reconnect, got %v", statuses)
+	}
+	byHost := make(map[string][]string)
+	for _, l := range lines {
+		if l.Time == 0 {
+			t.Fatalf("time of line not parsed %+v", l)
+		}
+		byHost[l.Host] = append(byHost[l.Host], l.Line[strings.LastIndex(l.Line, "|")+1:])
+	}
+	if strings.Join(byHost[remoteHost], ",") != "remote first" || strings.Join(byHost[getHostname()], ",") != "local first,local second" {
+		t.Fatalf("wrong merged lines %v", byHost)
+	}
+}
+
+func TestTailLogWSFilter(t *testing.T) {
+	defer func(i, d time.Duration) { TailInterval, MergeDelay = i, d }(TailInterval, MergeDelay)
+	TailInterval, MergeDelay = 10*time.Millisecond, 10*time.Millisecond
+	dir, err := ioutil.TempDir("", "tailws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(log, []byte("2021-05-06 11:27:01,000|main|INFO|c.App|ab12345|r1|started\n"+
+		"2021-05-06 11:27:02,000|main|ERROR|c.App|ab12345|r1|failed\n"), 0644)
+
+	done := make(chan bool)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HostTailWS(w, r)
+		close(done)
+	}))
+	defer s.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		c.Close()
+		<-done
+	}()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	read := func(lines bool) common.AppTailMessage {
+		for {
+			var m common.AppTailMessage
+			if err := c.ReadJSON(&m); err != nil {
+				t.Fatal(err)
+			}
+			if !lines || len(m.Lines) > 0 {
+				return m
+			}
+		}
+	}
+	c.WriteJSON(&common.TailLogRequest{Log: log, LogStructure: lsTime, Filter: &common.TailFilter{Level: "ERROR"}})
+	if m := read(true); len(m.Lines) != 1 || !strings.HasSuffix(m.Lines[0].Line, "failed") {
+		t.Fatalf("only error line expected %+v", m)
+	}
+
+	c.WriteJSON(&common.TailFilterMessage{Filter: &common.TailFilter{Level: "LOUD"}})
+	if m := read(false); m.Error == "" {
+		t.Fatalf("invalid filter should be reported %+v", m)
+	}
+	c.WriteJSON(&common.TailFilterMessage{Filter: &common.TailFilter{ReqID: "r2"}})
+	for m := read(false); m.Filter == nil || m.Filter.ReqID != "r2"; m = read(false) {
+	}
+	f, _ := os.OpenFile(log, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("2021-05-06 11:27:03,000|main|ERROR|c.App|ab12345|r1|other request\n" +
+		"2021-05-06 11:27:04,000|main|INFO|c.App|ab12345|r2|changed filter\n")
+	f.Close()
+	if m := read(true); len(m.Lines) != 1 || !strings.HasSuffix(m.Lines[0].Line, "changed filter") {
+		t.Fatalf("new filter should apply without reconnect %+v", m)
+	}
+}
+
+func TestReadyLines(t *testing.T) {
+	now := time.Now()
+	pending := []pendingLine{{line: common.AppTailLine{Time: 3, Line: "a"}, arrived: now.Add(-time.Second)},
+		{line: common.AppTailLine{Time: 1, Line: "b"}, arrived: now.Add(-time.Second)},
+		{line: common.AppTailLine{Time: 0, Line: "c"}, arrived: now}}
+	ready, rest := readyLines(pending, now.Add(-time.Millisecond))
+	if len(ready) != 2 || ready[0].Line != "b" || ready[1].Line != "a" || len(rest) != 1 || rest[0].line.Line != "c" {
+		t.Fatalf("wrong ready lines %+v, rest %+v", ready, rest)
+	}
+}
+
+func TestTailLogWSAuthorizedLog(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HostTailWS(w, r)
+	}))
+	defer s.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"?app=app&env=sit&log=/logs/app.log", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	c.WriteJSON(&common.TailLogRequest{Log: "/etc/passwd"})
+	var m common.AppTailMessage
+	if err := c.ReadJSON(&m); err != nil || !strings.Contains(m.Error, "does not match authorized") {
+		t.Fatalf("log other than authorized one should be rejected %+v, %v", m, err)
+	}
+}
+
+func TestModuleTailLogWS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tailws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "app.log")
+	//module tail skips first line of window as it may be partial
+	ioutil.WriteFile(log, []byte("partial\nstarted\n"), 0644)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		TailLogWS(w, r)
+	}))
+	defer s.Close()
+	dial := func() *websocket.Conn {
+		c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"?app=app&env=sit&log="+log, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return c
+	}
+
+	c := dial()
+	defer c.Close()
+	c.WriteJSON(&model.LogRequest{Log: log})
+	var res model.TailLogResponse
+	if err := c.ReadJSON(&res); err != nil || len(res.Lines) != 1 || res.Lines[0] != "started" {
+		t.Fatalf("tail of authorized log expected %+v, %v", res, err)
+	}
+
+	other := dial()
+	defer other.Close()
+	other.WriteJSON(&model.LogRequest{Log: "/etc/passwd"})
+	_, _, err = other.ReadMessage()
+	if ce, ok := err.(*websocket.CloseError); !ok || ce.Code != websocket.ClosePolicyViolation ||
+		!strings.Contains(ce.Text, "does not match authorized") {
+		t.Fatalf("log other than authorized one should be rejected, %v", err)
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/ws?app=App&env=sit&endpoint=http://h:8090/lvm/&log=/logs/app.log", nil)
+	for _, tc := range []struct {
+		values map[string]string
+		ok     bool
+	}{
+		{map[string]string{"app": "app", "env": "SIT"}, true},
+		{map[string]string{"app": "other", "env": "sit"}, false},
+		{map[string]string{"endpoint": "http://h:8090/lvm", "log": "/logs/./app.log"}, true},
+		{map[string]string{"endpoint": "http://h:8090/lvm", "log": "/LOGS/app.log"}, false},
+	} {
+		if err := authorized(r, tc.values); (err == nil) != tc.ok {
+			t.Fatalf("%v expected ok %v, got %v", tc.values, tc.ok, err)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package resolver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/agent"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+//BundleHostTimeout max time to download logs of a single host into bundle
+var BundleHostTimeout = 30 * time.Minute
+
+//rotationMark separator followed by date or number of rotated file
+var rotationMark = regexp.MustCompile(`^[._-](\d{4}-?\d{2}-?\d{2}([._T-]?\d{2,6})*|\d+)`)
+
+//ManifestEntry name of manifest entry, always last in archive
+const ManifestEntry = "manifest.json"
+
+//Bundle streams logs of all application hosts as zip or tar.gz, entries are named host/path
+//and manifest lists every file with status of every host
+func Bundle(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	req, err := bundleRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	app, err := appHosts(r.Context(), &req.AppRequest)
+	if err != nil {
+		return nil, err
+	}
+	a, err := newArchive(w, req.Format, fmt.Sprintf("%v-%v-%v", app.App, app.Env, time.Now().Format("20060102-150405")))
+	if err != nil {
+		return nil, err
+	}
+	tmp := &tempFiles{}
+	defer tmp.removeAll()
+	m := &common.BundleManifest{App: app.App, Env: app.Env, Created: time.Now().Unix(), Window: req.TimeWindow,
+		Files: make([]common.BundleFile, 0)}
+	m.Hosts = fanOutWithTimeout(r.Context(), app.Hosts, BundleHostTimeout, func(ctx context.Context, h common.HostDetails) (interface{}, error) {
+		return fetchBundle(ctx, h, req.TimeWindow, r.Header, tmp)
+	}, func(s *common.HostStatus, res interface{}) {
+		if res == nil {
+			return
+		}
+		for _, e := range res.([]*bundleEntry) {
+			if e.file.Error == "" {
+				if err := addFile(a, e); err != nil {
+					logger.Error(r.Context(), "Could not add %v to bundle, %v", e.file.Entry, err)
+					e.file.Error = err.Error()
+				}
+			}
+			if e.temp {
+				tmp.remove(e.source)
+			}
+			if e.file.Error == "" {
+				s.Matches++
+			}
+			m.Files = append(m.Files, e.file)
+		}
+	})
+	sort.SliceStable(m.Files, func(i, j int) bool {
+		return m.Files[i].Entry < m.Files[j].Entry
+	})
+	if err = addJSON(a, ManifestEntry, m); err != nil {
+		logger.Error(r.Context(), "Could not add manifest to bundle, %v", err)
+	}
+	if err = a.Close(); err != nil {
+		logger.Error(r.Context(), "Could not close bundle, %v", err)
+	}
+	return nil, nil
+}
+
+func bundleRequest(r *http.Request) (*common.BundleRequest, error) {
+	var req common.BundleRequest
+	if r.Method == http.MethodGet {
+		req.App = r.FormValue("app")
+		req.Env = r.FormValue("env")
+		req.Format = r.FormValue("format")
+		if v := r.FormValue("tags"); v != "" {
+			req.Tags = strings.Split(v, ",")
+		}
+		for _, p := range []struct {
+			name string
+			v    *int64
+		}{{"fromTime", &req.FromTime}, {"toTime", &req.ToTime}} {
+			if v := r.FormValue(p.name); v != "" {
+				t, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("Wrong %v '%v'", p.name, v)
+				}
+				*p.v = t
+			}
+		}
+		return &req, nil
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("Could not parse req body, %v", err)
+	}
+	return &req, nil
+}
+
+type bundleEntry struct {
+	file common.BundleFile
+	//source local log or downloaded temp file
+	source string
+	temp   bool
+}
+
+//fetchBundle selects host logs for bundle, logs of remote hosts are downloaded to temp files
+func fetchBundle(ctx context.Context, h common.HostDetails, window common.TimeWindow, headers http.Header,
+	tmp *tempFiles) ([]*bundleEntry, error) {
+	lds, err := hostLogs(ctx, h, headers)
+	if err != nil {
+		return nil, err
+	}
+	files := bundleFiles(h.Logs, lds, window)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No logs found on %v", h.LogViewerEndpoint)
+	}
+	host := parseHostName(ctx, h.LogViewerEndpoint)
+	local := isLocal(ctx, h.LogViewerEndpoint)
+	entries := make([]*bundleEntry, 0, len(files))
+	for _, f := range files {
+		e := &bundleEntry{file: common.BundleFile{Host: host, Path: f.Name, Entry: entryName(host, f.Name), Size: f.Size,
+			ModTime: f.ModTime}, source: f.Name}
+		if !local {
+			e.source, err = downloadTemp(ctx, h.LogViewerEndpoint, f.Name, headers, tmp)
+			e.temp = err == nil
+			if err != nil {
+				e.file.Error = err.Error()
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+//bundleFiles configured logs, with time window also rotated files of the same name which cover part of window
+func bundleFiles(logs []string, listed []model.LogDetails, window common.TimeWindow) []model.LogDetails {
+	out := make([]model.LogDetails, 0, len(logs))
+	seen := make(map[string]bool)
+	for _, l := range logs {
+		l = filepath.Clean(l)
+		dir, base := filepath.Split(l)
+		series := make([]model.LogDetails, 0)
+		for _, ld := range listed {
+			name := filepath.Clean(ld.Name)
+			if name == l || (window.IsSet() && filepath.Dir(name) == filepath.Clean(dir) && rotatedOf(filepath.Base(name), base)) {
+				ld.Name = name
+				series = append(series, ld)
+			}
+		}
+		sort.SliceStable(series, func(i, j int) bool {
+			return series[i].ModTime < series[j].ModTime
+		})
+		for i, ld := range series {
+			if seen[ld.Name] {
+				continue
+			}
+			if window.IsSet() {
+				//file holds lines written after previous file of series was rotated
+				var prev int64
+				if i > 0 {
+					prev = series[i-1].ModTime
+				}
+				if (window.FromTime > 0 && ld.ModTime < window.FromTime) || (window.ToTime > 0 && prev > window.ToTime) {
+					continue
+				}
+			}
+			seen[ld.Name] = true
+			out = append(out, ld)
+		}
+	}
+	return out
+}
+
+//rotatedOf name is log name or its stem followed by dates or numbers, optionally log extension and gzip, like
+//app.log.1, app.log.gz, app.2021-04-26.log or app-1.log.gz. Other logs of same prefix like app-error.log are not
+func rotatedOf(name, base string) bool {
+	ext := filepath.Ext(base)
+	for _, prefix := range []string{base, strings.TrimSuffix(base, ext)} {
+		rest := strings.TrimPrefix(name, prefix)
+		if rest == name {
+			continue
+		}
+		marked := false
+		for m := rotationMark.FindString(rest); m != ""; m = rotationMark.FindString(rest) {
+			rest, marked = rest[len(m):], true
+		}
+		if !marked && (prefix != base || rest != ".gz") {
+			continue
+		}
+		switch rest {
+		case "", ".gz", ext, ext + ".gz":
+			return true
+		}
+	}
+	return false
+}
+
+//entryName host/path, path is made relative so entries never leave archive root
+func entryName(host, p string) string {
+	parts := []string{host}
+	for _, s := range strings.Split(filepath.ToSlash(p), "/") {
+		if s == "" || s == "." || s == ".." {
+			continue
+		}
+		parts = append(parts, s)
+	}
+	return path.Join(parts...)
+}
+
+//downloadTemp copies remote log to temp file, transfer is gzip compressed
+func downloadTemp(ctx context.Context, endpoint string, log string, headers http.Header, tmp *tempFiles) (string, error) {
+	hs, err := agentHeaders(ctx, endpoint, headers)
+	if err != nil {
+		return "", err
+	}
+	resp, legacy, err := agentDownload(ctx, endpoint, log, true, hs)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var gr io.Reader = resp.Body
+	if !legacy {
+		if gr, err = gzip.NewReader(resp.Body); err != nil {
+			return "", fmt.Errorf("Could not read %v from %v, %v", log, endpoint, err)
+		}
+	}
+	f, err := tmp.create()
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, gr)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		tmp.remove(f.Name())
+		return "", fmt.Errorf("Could not download %v from %v, %v", log, endpoint, err)
+	}
+	return f.Name(), nil
+}
+
+//tempFiles downloaded files removed when bundle is done, also those of hosts which timed out
+type tempFiles struct {
+	mutex  sync.Mutex
+	names  map[string]bool
+	closed bool
+}
+
+func (t *tempFiles) create() (*os.File, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return nil, fmt.Errorf("Bundle already finished")
+	}
+	f, err := ioutil.TempFile("", "logviewer-bundle")
+	if err != nil {
+		return nil, fmt.Errorf("Could not create temp file, %v", err)
+	}
+	if t.names == nil {
+		t.names = make(map[string]bool)
+	}
+	t.names[f.Name()] = true
+	return f, nil
+}
+
+func (t *tempFiles) remove(name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.names, name)
+	os.Remove(name)
+}
+
+func (t *tempFiles) removeAll() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.closed = true
+	for name := range t.names {
+		os.Remove(name)
+	}
+	t.names = nil
+}
+
+//archive zip or tar.gz written straight to response
+type archive interface {
+	add(name string, size int64, mod time.Time, r io.Reader) error
+	Close() error
+}
+
+//newArchive archive of format, sets content type and attachment name with extension of format
+func newArchive(w http.ResponseWriter, format string, name string) (archive, error) {
+	var a archive
+	switch format {
+	case "", "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		name += ".zip"
+		a = &zipArchive{zip.NewWriter(w)}
+	case "tar.gz", "tgz":
+		w.Header().Set("Content-Type", "application/gzip")
+		name += ".tar.gz"
+		gw := gzip.NewWriter(w)
+		a = &tarArchive{tw: tar.NewWriter(gw), gw: gw}
+	default:
+		return nil, fmt.Errorf("Unsupported archive format '%v', use zip or tar.gz", format)
+	}
+	w.Header().Set("Content-Disposition", agent.Attachment(name))
+	return a, nil
+}
+
+//addFile copies source of entry, size is fixed when file is opened so growing logs are cut there
+func addFile(a archive, e *bundleEntry) error {
+	f, err := os.Open(e.source)
+	if err != nil {
+		return fmt.Errorf("Could not open %v, %v", e.file.Path, err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("Could not stat %v, %v", e.file.Path, err)
+	}
+	e.file.Size = stat.Size()
+	return a.add(e.file.Entry, stat.Size(), time.Unix(e.file.ModTime, 0), io.LimitReader(f, stat.Size()))
+}
+
+//addJSON adds indented json entry
+func addJSON(a archive, name string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Could not marshal %v, %v", name, err)
+	}
+	return a.add(name, int64(len(b)), time.Now(), bytes.NewReader(b))
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (z *zipArchive) add(name string, size int64, mod time.Time, r io.Reader) error {
+	w, err := z.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mod})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (z *zipArchive) Close() error {
+	return z.zw.Close()
+}
+
+type tarArchive struct {
+	tw *tar.Writer
+	gw *gzip.Writer
+}
+
+//add writes exactly size bytes, short entries are padded with zeros so archive stays readable
+func (t *tarArchive) add(name string, size int64, mod time.Time, r io.Reader) error {
+	err := t.tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: mod, Typeflag: tar.TypeReg})
+	if err != nil {
+		return err
+	}
+	n, err := io.CopyN(t.tw, r, size)
+	if n < size {
+		if _, perr := io.CopyN(t.tw, zeros{}, size-n); perr != nil {
+			return perr
+		}
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("%v truncated at %v of %v bytes", name, n, size)
+		}
+	}
+	return err
+}
+
+func (t *tarArchive) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gw.Close()
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,165 @@
+package resolver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/agent"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+func rotatedLogs(t *testing.T) (string, time.Time) {
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	now := time.Now()
+	for name, age := range map[string]time.Duration{"app.log": 0, "app.log.1": time.Hour, "app.log.2": 72 * time.Hour,
+		"application.log": 0} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, now
+}
+
+func bundle(t *testing.T, hosts []common.HostDetails, req *common.BundleRequest) *httptest.ResponseRecorder {
+	AppResolver = func(ctx context.Context, req *common.AppRequest) (*common.AppHosts, error) {
+		return &common.AppHosts{App: req.App, Env: req.Env, Hosts: hosts}, nil
+	}
+	defer func() { AppResolver = nil }()
+	req.App, req.Env = "app", "sit"
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	if _, err := Bundle(rr, httptest.NewRequest("POST", "/log-bundle", bytes.NewReader(b))); err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestLocalBundle(t *testing.T) {
+	dir, now := rotatedLogs(t)
+	failing := httptest.NewServer(http.NotFoundHandler())
+	defer failing.Close()
+	hosts := []common.HostDetails{{LogViewerEndpoint: localLVMEndpoint, Logs: []string{filepath.Join(dir, "app.log")}},
+		{LogViewerEndpoint: failing.URL + "/iq-logviewer/lvm", Logs: []string{"app.log"}}}
+
+	rr := bundle(t, hosts, &common.BundleRequest{TimeWindow: common.TimeWindow{FromTime: now.Add(-2 * time.Hour).Unix()}})
+	if !strings.Contains(rr.Header().Get("Content-Disposition"), ".zip") {
+		t.Fatalf("wrong content disposition %v", rr.Header().Get("Content-Disposition"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := parseHostName(context.Background(), localLVMEndpoint)
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		entries[f.Name] = string(b)
+	}
+	prefix := entryName(host, dir) + "/"
+	if len(entries) != 3 || entries[prefix+"app.log"] != "app.log\n" || entries[prefix+"app.log.1"] != "app.log.1\n" {
+		t.Fatalf("wrong bundle entries %v", entries)
+	}
+	var m common.BundleManifest
+	if err := json.Unmarshal([]byte(entries[ManifestEntry]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 2 || len(m.Hosts) != 2 || m.Hosts[0].Status != common.StatusOK || m.Hosts[1].Status != common.StatusError {
+		t.Fatalf("wrong manifest %+v", m)
+	}
+}
+
+func TestRemoteAgentBundle(t *testing.T) {
+	dir, _ := rotatedLogs(t)
+	log := filepath.Join(dir, "app.log")
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/iq-logviewer/lvm/" + model.ListLogsEndpoint:
+			json.NewEncoder(w).Encode(lapi.ListLogs(r.Context(), &model.ListLogsRequest{Logs: []string{log}}))
+		case "/iq-logviewer/lvm/" + common.AgentDownloadEndpoint:
+			var req common.AgentDownloadRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			agent.ServeLog(w, r, req.Log, req.Gzip)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer fake.Close()
+	//agent which was not upgraded yet sends plain file
+	legacy := legacyAgent()
+	defer legacy.Close()
+	for _, peer := range []*httptest.Server{fake, legacy} {
+		hosts := []common.HostDetails{{LogViewerEndpoint: peer.URL + "/iq-logviewer/lvm", Logs: []string{log}}}
+
+		rr := bundle(t, hosts, &common.BundleRequest{Format: "tar.gz"})
+		gr, err := gzip.NewReader(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gr)
+		names := make([]string, 0)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := ioutil.ReadAll(tr)
+			if h.Name != ManifestEntry && string(b) != "app.log\n" {
+				t.Fatalf("wrong content of %v, %v", h.Name, string(b))
+			}
+			names = append(names, h.Name)
+		}
+		host := parseHostName(context.Background(), hosts[0].LogViewerEndpoint)
+		if len(names) != 2 || names[0] != entryName(host, log) || names[1] != ManifestEntry {
+			t.Fatalf("wrong bundle entries %v", names)
+		}
+	}
+}
+
+func TestRotatedOf(t *testing.T) {
+	for name, rotated := range map[string]bool{"app.log.1": true, "app.log.1.gz": true, "app.log.gz": true,
+		"app.2021-04-26.log": true, "app-1.log.gz": true, "app_20210426.log": true, "app.log.2021-04-26_10": true,
+		"app-error.log": false, "application.log": false, "app.log.bak": false, "app-1.txt": false, "app.log": false} {
+		if rotatedOf(name, "app.log") != rotated {
+			t.Fatalf("%v rotated should be %v", name, rotated)
+		}
+	}
+}
+
+func TestEntryName(t *testing.T) {
+	if n := entryName("host1", "../../var/log/app.log"); n != "host1/var/log/app.log" {
+		t.Fatalf("entry must stay inside host dir, got %v", n)
+	}
+}
@@ -0,0 +1,166 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+	"github.com/RomanLorens/logviewer/httpclient"
+	"github.com/RomanLorens/logviewer/parser"
+)
+
+//errPagingUnsupported agent which was not upgraded yet answers module errors endpoint of first page only
+var errPagingUnsupported = fmt.Errorf("Logviewer does not support paging of errors, only first page is served")
+
+type logErrors struct {
+	log string
+	res *model.ErrorDetailsPagination
+	err error
+}
+
+//AppErrors merges errors from all hosts and logs of application, latest first.
+//Every page returns cursor of next one, errors logged after first page was served never show up on next pages
+func AppErrors(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req common.AppErrorsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse req body, %v", err)
+	}
+	if req.Size <= 0 || (req.Next != nil && req.Next.Skip < 0) {
+		return nil, fmt.Errorf("Invalid size %v or cursor", req.Size)
+	}
+	app, err := appHosts(r.Context(), &req.AppRequest)
+	if err != nil {
+		return nil, err
+	}
+	if app.LogStructure == nil {
+		return nil, fmt.Errorf("Missing log structure for %v %v", app.App, app.Env)
+	}
+	cursor := req.Next
+	if cursor == nil {
+		cursor = &common.AppErrorsCursor{Time: -1}
+	}
+	//every log sorted latest first, page of merged stream needs at most that many errors from each log
+	er := common.AgentErrorsRequest{
+		ErrorsRequest: &model.ErrorsRequest{From: 0, Size: req.Size + cursor.Skip},
+		TimeWindow:    req.TimeWindow,
+		Events:        req.Events,
+	}
+	switch {
+	case cursor.Time > 0:
+		er.Before = cursor.Time
+	case cursor.Time == 0:
+		//only errors without time are left
+		er.Before = 1
+	}
+
+	out := &common.AppErrorsResponse{Errors: make([]common.AppError, 0)}
+	total := 0
+	out.Hosts = fanOut(r.Context(), app.Hosts, func(ctx context.Context, h common.HostDetails) (interface{}, error) {
+		return logsErrors(ctx, h, app.LogStructure, er, r.Header)
+	}, func(s *common.HostStatus, res interface{}) {
+		if res == nil {
+			if s.Error == errPagingUnsupported.Error() {
+				s.Status = common.StatusSkipped
+			}
+			return
+		}
+		for _, le := range res.([]logErrors) {
+			total += le.res.Pagination.Total
+			s.Matches += le.res.Pagination.Total
+			for _, e := range le.res.ErrorDetails {
+				t, _ := parser.ParseTime(e.Date, app.LogStructure.DateFormat)
+				out.Errors = append(out.Errors, common.AppError{ErrorDetails: e, Time: parser.Millis(t),
+					Host: s.Host, LogFile: le.log})
+			}
+		}
+	})
+	sortAppErrors(out.Errors)
+	out.Errors = nextPage(out.Errors, cursor, req.Size)
+	total -= cursor.Skip
+	out.Pagination = &model.Pagination{Size: req.Size, Total: total}
+	if n := len(out.Errors); n > 0 && total > n {
+		last := out.Errors[n-1].Time
+		out.Next = &common.AppErrorsCursor{Time: last}
+		for _, e := range out.Errors {
+			if e.Time == last {
+				out.Next.Skip++
+			}
+		}
+		if last == cursor.Time {
+			out.Next.Skip += cursor.Skip
+		}
+	}
+	return out, nil
+}
+
+//nextPage errors after cursor, errors are sorted so those with cursor time already served come first
+func nextPage(errs []common.AppError, cursor *common.AppErrorsCursor, size int) []common.AppError {
+	start, skip := 0, cursor.Skip
+	for ; start < len(errs); start++ {
+		e := errs[start]
+		if cursor.Time >= 0 && e.Time > cursor.Time {
+			continue
+		}
+		if e.Time == cursor.Time && skip > 0 {
+			skip--
+			continue
+		}
+		break
+	}
+	end := start + size
+	if end > len(errs) {
+		end = len(errs)
+	}
+	return errs[start:end]
+}
+
+//logsErrors errors from every log of host, logs are queried concurrently
+func logsErrors(ctx context.Context, h common.HostDetails, ls *model.LogStructure, er common.AgentErrorsRequest,
+	headers http.Header) ([]logErrors, error) {
+	out := make([]logErrors, len(h.Logs))
+	var wg sync.WaitGroup
+	for i, log := range h.Logs {
+		wg.Add(1)
+		go func(i int, log string) {
+			defer wg.Done()
+			req := er
+			errorsReq := *er.ErrorsRequest
+			errorsReq.StatsRequest = &model.StatsRequest{Log: log, LogStructure: ls}
+			req.ErrorsRequest = &errorsReq
+			res, err := hostErrors(ctx, h.LogViewerEndpoint, &req, headers)
+			out[i] = logErrors{log: log, err: err}
+			if err == nil {
+				out[i].res = res.(*model.ErrorDetailsPagination)
+			}
+		}(i, log)
+	}
+	wg.Wait()
+	for _, le := range out {
+		if le.err != nil && er.Before != 0 && httpclient.NotFound(le.err) {
+			return nil, errPagingUnsupported
+		}
+		if le.err != nil {
+			return nil, fmt.Errorf("Could not get errors from %v, %v", le.log, le.err)
+		}
+	}
+	return out, nil
+}
+
+//sortAppErrors latest first, ties keep host, log and in-log order so pages are stable
+func sortAppErrors(errs []common.AppError) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Time != errs[j].Time {
+			return errs[i].Time > errs[j].Time
+		}
+		if errs[i].Host != errs[j].Host {
+			return errs[i].Host < errs[j].Host
+		}
+		return errs[i].LogFile < errs[j].LogFile
+	})
+}
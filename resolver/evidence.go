@@ -0,0 +1,183 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+	"github.com/RomanLorens/logviewer/parser"
+)
+
+var (
+	//AppConfig config of application added to support package, set on server start
+	AppConfig func(ctx context.Context, req *common.AppRequest) (interface{}, error)
+	//MaxEvidenceLines max lines or events collected from single host into support package
+	MaxEvidenceLines = 50000
+)
+
+const (
+	//TimelineEntry all collected lines of support package ordered by time
+	TimelineEntry = "timeline.log"
+	//StatsEntry stats of support package period
+	StatsEntry = "stats.json"
+	//ConfigEntry application config
+	ConfigEntry = "config.json"
+)
+
+//Evidence support package of application as zip or tar.gz, collects lines of request id or of time window
+//from all hosts with stats of that period and application config
+func Evidence(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req common.EvidenceRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse req body, %v", err)
+	}
+	if req.ReqID == "" && !req.IsSet() {
+		return nil, fmt.Errorf("Must pass reqid or time window")
+	}
+	app, err := appHosts(r.Context(), &req.AppRequest)
+	if err != nil {
+		return nil, err
+	}
+	if app.LogStructure == nil {
+		return nil, fmt.Errorf("Missing log structure for %v %v", app.App, app.Env)
+	}
+	label := req.ReqID
+	if label == "" {
+		label = time.Unix(req.FromTime, 0).Format("20060102-150405")
+	}
+	a, err := newArchive(w, req.Format, fmt.Sprintf("%v-%v-support-%v", app.App, app.Env, label))
+	if err != nil {
+		return nil, err
+	}
+	m := &common.EvidenceManifest{App: app.App, Env: app.Env, ReqID: req.ReqID, Created: time.Now().Unix(),
+		Files: make([]common.BundleFile, 0)}
+	lines := evidenceLines(r.Context(), app, &req, m, r.Header)
+	m.Window = req.TimeWindow
+	if !m.Window.IsSet() && len(lines) > 0 {
+		m.Window = common.TimeWindow{FromTime: lines[0].Time / 1000, ToTime: lines[len(lines)-1].Time/1000 + 1}
+	}
+	stats := appStats(r.Context(), app, m.Window, r.Header)
+	m.Stats = stats.Hosts
+
+	for _, f := range linesFiles(lines) {
+		if err := addEvidence(a, m, f.file, f.data); err != nil {
+			break
+		}
+	}
+	if err := addEvidence(a, m, common.BundleFile{Entry: TimelineEntry}, timeline(lines)); err == nil {
+		if err = addJSON(a, StatsEntry, stats); err != nil {
+			m.Errors = append(m.Errors, err.Error())
+		}
+		if AppConfig != nil {
+			cfg, err := AppConfig(r.Context(), &req.AppRequest)
+			if err == nil {
+				err = addJSON(a, ConfigEntry, cfg)
+			}
+			if err != nil {
+				m.Errors = append(m.Errors, fmt.Sprintf("Could not add config, %v", err))
+			}
+		}
+	}
+	if err = addJSON(a, ManifestEntry, m); err != nil {
+		logger.Error(r.Context(), "Could not add manifest to support package, %v", err)
+	}
+	if err = a.Close(); err != nil {
+		logger.Error(r.Context(), "Could not close support package, %v", err)
+	}
+	return nil, nil
+}
+
+//evidenceLines events of request id or time window from all hosts ordered by time
+func evidenceLines(ctx context.Context, app *common.AppHosts, req *common.EvidenceRequest, m *common.EvidenceManifest,
+	headers http.Header) []common.TraceLine {
+	gr := common.GrepRequest{GrepRequest: model.GrepRequest{Value: req.ReqID}, LogStructure: app.LogStructure,
+		TimeWindow: req.TimeWindow, Events: true, MaxResults: MaxEvidenceLines}
+	out := make([]common.TraceLine, 0)
+	m.Hosts = fanOut(ctx, app.Hosts, func(ctx context.Context, h common.HostDetails) (interface{}, error) {
+		return grep(ctx, h, gr, headers)
+	}, func(s *common.HostStatus, res interface{}) {
+		if res == nil {
+			return
+		}
+		total := 0
+		for _, g := range res.([]common.GrepResponse) {
+			total += len(g.Lines)
+			for _, line := range g.Lines {
+				l := parser.ParseEvent(line, app.LogStructure)
+				if req.ReqID != "" && (l == nil || l.ReqID != req.ReqID) {
+					continue
+				}
+				tl := common.TraceLine{Host: s.Host, LogFile: g.LogFile, Line: line}
+				if l != nil {
+					tl.Time, tl.Date = parser.Millis(l.Time), l.Date
+				}
+				out = append(out, tl)
+				s.Matches++
+			}
+		}
+		if total >= MaxEvidenceLines {
+			m.Truncated = true
+		}
+	})
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Time < out[j].Time
+	})
+	m.Lines = len(out)
+	return out
+}
+
+type evidenceFile struct {
+	file common.BundleFile
+	data []byte
+}
+
+//linesFiles lines grouped into host/path entries, order of lines is kept
+func linesFiles(lines []common.TraceLine) []evidenceFile {
+	idx := make(map[string]int)
+	out := make([]evidenceFile, 0)
+	for _, l := range lines {
+		name := entryName(l.Host, l.LogFile)
+		i, ok := idx[name]
+		if !ok {
+			i = len(out)
+			idx[name] = i
+			out = append(out, evidenceFile{file: common.BundleFile{Host: l.Host, Path: l.LogFile, Entry: name}})
+		}
+		out[i].data = append(out[i].data, l.Line...)
+		out[i].data = append(out[i].data, '\n')
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].file.Entry < out[j].file.Entry
+	})
+	return out
+}
+
+//timeline every line prefixed with host and log file
+func timeline(lines []common.TraceLine) []byte {
+	var b bytes.Buffer
+	for _, l := range lines {
+		fmt.Fprintf(&b, "[%v %v] %v\n", l.Host, l.LogFile, strings.TrimRight(l.Line, "\n"))
+	}
+	return b.Bytes()
+}
+
+//addEvidence adds entry and records it in manifest
+func addEvidence(a archive, m *common.EvidenceManifest, f common.BundleFile, data []byte) error {
+	f.Size = int64(len(data))
+	f.ModTime = time.Now().Unix()
+	err := a.add(f.Entry, f.Size, time.Unix(f.ModTime, 0), bytes.NewReader(data))
+	if err != nil {
+		f.Error = err.Error()
+		m.Errors = append(m.Errors, fmt.Sprintf("Could not add %v, %v", f.Entry, err))
+	}
+	m.Files = append(m.Files, f)
+	return err
+}
@@ -0,0 +1,67 @@
+package resolver
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RomanLorens/logviewer/common"
+)
+
+func TestLocalEvidence(t *testing.T) {
+	defer useLocalApp()()
+	AppConfig = func(ctx context.Context, req *common.AppRequest) (interface{}, error) {
+		return map[string]string{"application": req.App}, nil
+	}
+	defer func() { AppConfig = nil }()
+	reqid := "1-01-CV-QCVMW9XPMLMMUMJKKJNTCR1ETMKB9EG133396101@1-249009#6"
+	b, err := json.Marshal(&common.EvidenceRequest{AppRequest: common.AppRequest{App: "java-app", Env: "sit"}, ReqID: reqid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	if _, err := Evidence(rr, httptest.NewRequest("POST", "/support-package", bytes.NewReader(b))); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		entries[f.Name] = string(b)
+	}
+	host := parseHostName(context.Background(), localLVMEndpoint)
+	lines := strings.Split(strings.TrimSpace(entries[entryName(host, localLog)]), "\n")
+	if len(lines) != 4 || len(strings.Split(strings.TrimSpace(entries[TimelineEntry]), "\n")) != 4 {
+		t.Fatalf("expected 4 lines of reqid, got %v", entries)
+	}
+	if entries[StatsEntry] == "" || !strings.Contains(entries[ConfigEntry], "java-app") {
+		t.Fatalf("missing stats or config %v", entries)
+	}
+	var m common.EvidenceManifest
+	if err := json.Unmarshal([]byte(entries[ManifestEntry]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Lines != 4 || !m.Window.IsSet() || len(m.Files) != 2 || m.Hosts[0].Status != common.StatusOK || len(m.Errors) > 0 {
+		t.Fatalf("wrong manifest %+v", m)
+	}
+}
+
+func TestEvidenceMissingReqIDAndWindow(t *testing.T) {
+	b := []byte(`{"app":"java-app","env":"sit"}`)
+	if _, err := Evidence(httptest.NewRecorder(), httptest.NewRequest("POST", "/support-package", bytes.NewReader(b))); err == nil {
+		t.Fatal("reqid or time window is required")
+	}
+}
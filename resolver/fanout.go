@@ -0,0 +1,109 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RomanLorens/logviewer-module/utils"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+var (
+	//HostTimeout max time to wait for a single host
+	HostTimeout = 30 * time.Second
+	//MaxConcurrentHosts max number of hosts queried at the same time
+	MaxConcurrentHosts = 8
+)
+
+type hostCall func(ctx context.Context, h common.HostDetails) (interface{}, error)
+
+//hostDone is called once per host, never concurrently, res is nil when host failed
+type hostDone func(s *common.HostStatus, res interface{})
+
+//fanOut calls all hosts concurrently and returns status per host in hosts order
+func fanOut(ctx context.Context, hosts []common.HostDetails, call hostCall, done hostDone) []common.HostStatus {
+	return fanOutWithTimeout(ctx, hosts, HostTimeout, call, done)
+}
+
+func fanOutWithTimeout(ctx context.Context, hosts []common.HostDetails, timeout time.Duration, call hostCall, done hostDone) []common.HostStatus {
+	statuses := make([]common.HostStatus, len(hosts))
+	limit := MaxConcurrentHosts
+	if limit <= 0 || limit > len(hosts) {
+		limit = len(hosts)
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for i, h := range hosts {
+		wg.Add(1)
+		go func(i int, h common.HostDetails) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				statuses[i] = common.HostStatus{Host: parseHostName(ctx, h.LogViewerEndpoint), Endpoint: h.LogViewerEndpoint}
+				setCtxError(&statuses[i], ctx.Err())
+				return
+			}
+			s, res := callHost(ctx, h, timeout, call)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if done != nil {
+				done(s, res)
+			}
+			statuses[i] = *s
+		}(i, h)
+	}
+	wg.Wait()
+	return statuses
+}
+
+func callHost(ctx context.Context, h common.HostDetails, timeout time.Duration, call hostCall) (*common.HostStatus, interface{}) {
+	s := &common.HostStatus{Host: parseHostName(ctx, h.LogViewerEndpoint), Endpoint: h.LogViewerEndpoint}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		value interface{}
+		err   error
+	}
+	c := make(chan result, 1)
+	go func() {
+		res := result{err: fmt.Errorf("call to %v panicked", h.LogViewerEndpoint)}
+		defer func() { c <- res }()
+		defer utils.CatchError(ctx, logger)
+		res.value, res.err = call(ctx, h)
+	}()
+
+	var value interface{}
+	select {
+	case res := <-c:
+		if res.err != nil {
+			s.Status = common.StatusError
+			s.Error = res.err.Error()
+		} else {
+			s.Status = common.StatusOK
+			value = res.value
+		}
+	case <-ctx.Done():
+		setCtxError(s, ctx.Err())
+	}
+	s.Latency = time.Since(start).Milliseconds()
+	if s.Status != common.StatusOK {
+		logger.Error(ctx, "%v %v, %v", h.LogViewerEndpoint, s.Status, s.Error)
+	}
+	return s, value
+}
+
+func setCtxError(s *common.HostStatus, err error) {
+	s.Error = err.Error()
+	if err == context.DeadlineExceeded {
+		s.Status = common.StatusTimeout
+		return
+	}
+	s.Status = common.StatusError
+}
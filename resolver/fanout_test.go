@@ -0,0 +1,80 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RomanLorens/logviewer/common"
+)
+
+func TestFanOutStatuses(t *testing.T) {
+	timeout := HostTimeout
+	HostTimeout = 100 * time.Millisecond
+	defer func() { HostTimeout = timeout }()
+
+	hosts := []common.HostDetails{
+		{LogViewerEndpoint: "https://ok.host:8090/iq-logviewer/lvm"},
+		{LogViewerEndpoint: "https://slow.host:8090/iq-logviewer/lvm"},
+		{LogViewerEndpoint: "https://failing.host:8090/iq-logviewer/lvm"},
+	}
+	answered := 0
+	statuses := fanOut(context.Background(), hosts, func(ctx context.Context, h common.HostDetails) (interface{}, error) {
+		switch parseHostName(ctx, h.LogViewerEndpoint) {
+		case "slow.host":
+			time.Sleep(time.Second)
+			return 1, nil
+		case "failing.host":
+			return nil, fmt.Errorf("connection refused")
+		}
+		return 1, nil
+	}, func(s *common.HostStatus, res interface{}) {
+		if res != nil {
+			answered++
+		}
+	})
+
+	if answered != 1 {
+		t.Fatalf("expected 1 answered host, got %v", answered)
+	}
+	expected := []string{common.StatusOK, common.StatusTimeout, common.StatusError}
+	for i, s := range statuses {
+		if s.Status != expected[i] {
+			t.Fatalf("%v should be %v, got %v", s.Host, expected[i], s.Status)
+		}
+	}
+	if statuses[2].Error != "connection refused" {
+		t.Fatalf("unexpected error '%v'", statuses[2].Error)
+	}
+}
+
+func TestFanOutConcurrencyCap(t *testing.T) {
+	max := MaxConcurrentHosts
+	MaxConcurrentHosts = 2
+	defer func() { MaxConcurrentHosts = max }()
+
+	hosts := make([]common.HostDetails, 6)
+	for i := range hosts {
+		hosts[i] = common.HostDetails{LogViewerEndpoint: fmt.Sprintf("https://host%v:8090", i)}
+	}
+	var mutex sync.Mutex
+	running, peak := 0, 0
+	fanOut(context.Background(), hosts, func(ctx context.Context, h common.HostDetails) (interface{}, error) {
+		mutex.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return nil, nil
+	}, nil)
+	if peak > 2 {
+		t.Fatalf("expected at most 2 concurrent calls, got %v", peak)
+	}
+}
@@ -0,0 +1,60 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	log "github.com/RomanLorens/logger/log"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+var (
+	//ForwardHeaders incoming headers passed to agents, set on server start
+	ForwardHeaders = []string{"x-citiportal-ssoid", "x-citiportal-LoginID", common.RequestIDHeader, "Authorization"}
+	//ServiceToken bearer token of this aggregator, replaces forwarded authorization of configured hosts when set
+	ServiceToken string
+	//KnownEndpoint reports endpoint is logviewer of configured app host, set on server start
+	KnownEndpoint func(endpoint string) bool
+	//never forwarded even when allowlisted
+	hopByHop = map[string]bool{"Connection": true, "Keep-Alive": true, "Proxy-Authenticate": true,
+		"Proxy-Authorization": true, "Te": true, "Trailer": true, "Transfer-Encoding": true, "Upgrade": true,
+		"Cookie": true, "Host": true, "Content-Length": true}
+)
+
+//agentHeaders allowlisted incoming headers with service credential and original user, endpoints which are not
+//configured hosts are rejected so credentials are never sent to endpoint passed by user
+func agentHeaders(ctx context.Context, endpoint string, in http.Header) (http.Header, error) {
+	if KnownEndpoint != nil && !KnownEndpoint(endpoint) {
+		return nil, fmt.Errorf("Logviewer %v is not configured host of any app", endpoint)
+	}
+	out := make(http.Header)
+	for _, h := range ForwardHeaders {
+		k := http.CanonicalHeaderKey(h)
+		if hopByHop[k] {
+			continue
+		}
+		for _, v := range in[k] {
+			out.Add(k, v)
+		}
+	}
+	if id, ok := ctx.Value(log.ReqID).(string); ok && id != "" && out.Get(common.RequestIDHeader) == "" {
+		out.Set(common.RequestIDHeader, id)
+	}
+	if ServiceToken != "" && KnownEndpoint != nil {
+		out.Set("Authorization", "Bearer "+ServiceToken)
+	}
+	if u, ok := ctx.Value(log.UserKey).(string); ok && u != "" {
+		out.Set(common.OriginalUserHeader, u)
+	}
+	return out, nil
+}
+
+//serviceHeaders request id only, agent calls outliving client request authenticate with service token
+func serviceHeaders(ctx context.Context) http.Header {
+	out := make(http.Header)
+	if id, ok := ctx.Value(log.ReqID).(string); ok && id != "" {
+		out.Set(common.RequestIDHeader, id)
+	}
+	return out
+}
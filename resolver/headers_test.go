@@ -0,0 +1,45 @@
+package resolver
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	log "github.com/RomanLorens/logger/log"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+func TestAgentHeaders(t *testing.T) {
+	in := make(http.Header)
+	in.Set("Cookie", "session=secret")
+	in.Set("Connection", "keep-alive")
+	in.Set("x-citiportal-ssoid", "ab12345")
+	in.Set("Authorization", "Bearer user-token")
+	in.Set("X-Custom", "value")
+	ctx := context.WithValue(context.Background(), log.UserKey, "ab12345")
+	ctx = context.WithValue(ctx, log.ReqID, "req-1")
+
+	out, err := agentHeaders(ctx, "http://agent:8090/lvm", in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Get("Cookie") != "" || out.Get("Connection") != "" || out.Get("X-Custom") != "" {
+		t.Fatalf("not allowlisted headers forwarded %v", out)
+	}
+	if out.Get("x-citiportal-ssoid") != "ab12345" || out.Get("Authorization") != "Bearer user-token" {
+		t.Fatalf("allowlisted headers missing %v", out)
+	}
+	if out.Get(common.RequestIDHeader) != "req-1" || out.Get(common.OriginalUserHeader) != "ab12345" {
+		t.Fatalf("request id or original user missing %v", out)
+	}
+
+	ServiceToken = "svc-token"
+	KnownEndpoint = func(endpoint string) bool { return endpoint == "http://agent:8090/lvm" }
+	defer func() { ServiceToken, KnownEndpoint = "", nil }()
+	if out, _ = agentHeaders(ctx, "http://agent:8090/lvm", in); out.Get("Authorization") != "Bearer svc-token" {
+		t.Fatalf("service credential should replace authorization, got '%v'", out.Get("Authorization"))
+	}
+	if _, err = agentHeaders(ctx, "http://attacker/lvm", in); err == nil {
+		t.Fatal("endpoint which is not configured host should be rejected")
+	}
+}
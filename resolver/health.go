@@ -0,0 +1,78 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	h "github.com/RomanLorens/logviewer-module/handler"
+)
+
+var (
+	//HealthChecks health urls of applications, set on server start
+	HealthChecks func() []h.Health
+	//HealthTimeout max wait for single health url
+	HealthTimeout = 10 * time.Second
+	//HealthRetry wait before server sent events client checks health again
+	HealthRetry  = 30 * time.Second
+	healthClient = &http.Client{}
+)
+
+//checkHealths checks all health urls at once, every health is sent as soon as it is known, status 0 when url
+//could not be reached
+func checkHealths(ctx context.Context, send func(health *h.Health) error) {
+	if HealthChecks == nil {
+		return
+	}
+	checks := HealthChecks()
+	out := make(chan *h.Health, len(checks))
+	for i := range checks {
+		go func(health h.Health) {
+			health.Status = checkHealth(ctx, health.Host)
+			out <- &health
+		}(checks[i])
+	}
+	for range checks {
+		select {
+		case health := <-out:
+			if err := send(health); err != nil {
+				logger.Error(ctx, "Could not send health of %v, %v", health.Host, err)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func checkHealth(ctx context.Context, url string) int {
+	ctx, cancel := context.WithTimeout(ctx, HealthTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		logger.Error(ctx, "Could not create health req for %v, %v", url, err)
+		return 0
+	}
+	resp, err := healthClient.Do(req)
+	if err != nil {
+		logger.Error(ctx, "Health check of %v failed, %v", url, err)
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+//AppsHealthWS checks health of all applications, every health is message and socket is closed after last one
+func AppsHealthWS(w http.ResponseWriter, r *http.Request) error {
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return fmt.Errorf("Could not create websocket, %v", err)
+	}
+	defer c.Close()
+	checkHealths(r.Context(), func(health *h.Health) error {
+		return c.WriteJSON(health)
+	})
+	closeWS(c)
+	return nil
+}
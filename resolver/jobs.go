@@ -0,0 +1,247 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/RomanLorens/logger/log"
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+	"github.com/RomanLorens/logviewer/parser"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+var (
+	//JobHostTimeout max time to wait for a single host in search job
+	JobHostTimeout = 30 * time.Minute
+	//JobTTL how long finished jobs are kept
+	JobTTL = time.Hour
+	//MaxUserJobs max running search jobs of one user
+	MaxUserJobs = 3
+	//MaxJobResults max matched lines kept by search job, job stops and skips remaining hosts when reached
+	MaxJobResults = 100000
+	jobs          = &jobStore{jobs: make(map[string]*job)}
+)
+
+type jobStore struct {
+	mutex sync.Mutex
+	jobs  map[string]*job
+}
+
+type job struct {
+	mutex    sync.Mutex
+	id       string
+	user     string
+	status   string
+	hosts    []common.HostStatus
+	results  []common.GrepResponse
+	matches  int
+	started  time.Time
+	finished time.Time
+	cancel   context.CancelFunc
+	//truncated results reached MaxJobResults
+	truncated bool
+}
+
+//SubmitSearchJob starts search in background and returns job progress with id
+func SubmitSearchJob(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req common.SearchRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse req body, %v", err)
+	}
+	hosts, gr, err := searchHosts(r.Context(), &req)
+	if err != nil {
+		return nil, err
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("Could not create job id, %v", err)
+	}
+	j := &job{id: id.String(), user: requestUser(r), status: common.JobRunning, started: time.Now(),
+		hosts: make([]common.HostStatus, len(hosts)), results: make([]common.GrepResponse, 0)}
+	for i, h := range hosts {
+		j.hosts[i] = common.HostStatus{Host: parseHostName(r.Context(), h.LogViewerEndpoint), Endpoint: h.LogViewerEndpoint,
+			Status: common.StatusPending}
+	}
+	if MaxJobResults > 0 {
+		//one more to know if results were truncated
+		gr.MaxResults = MaxJobResults + 1
+	}
+	//job outlives request
+	ctx := context.WithValue(context.Background(), log.UserKey, j.user)
+	ctx = context.WithValue(ctx, log.ReqID, j.id)
+	ctx, j.cancel = context.WithCancel(ctx)
+	if err := jobs.add(j); err != nil {
+		j.cancel()
+		return nil, err
+	}
+	logger.Info(r.Context(), "Submitted search job %v for %v hosts", j.id, len(hosts))
+	go j.run(ctx, hosts, *gr, r.Header.Clone())
+	return j.progress(), nil
+}
+
+//SearchJobStatus search job progress per host
+func SearchJobStatus(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	j, err := jobs.get(r)
+	if err != nil {
+		return nil, err
+	}
+	return j.progress(), nil
+}
+
+//SearchJobResults page of search job results from offset, available while job is running
+func SearchJobResults(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	j, err := jobs.get(r)
+	if err != nil {
+		return nil, err
+	}
+	from, size := 0, 100
+	if v := r.FormValue("from"); v != "" {
+		if from, err = strconv.Atoi(v); err != nil || from < 0 {
+			return nil, fmt.Errorf("Invalid from '%v'", v)
+		}
+	}
+	if v := r.FormValue("size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size <= 0 {
+			return nil, fmt.Errorf("Invalid size '%v'", v)
+		}
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	res := &common.JobResults{Results: []common.GrepResponse{},
+		Pagination: &model.Pagination{From: from, Size: size, Total: len(j.results)}}
+	end := from + size
+	if end > len(j.results) {
+		end = len(j.results)
+	}
+	if from < end {
+		res.Results = append(res.Results, j.results[from:end]...)
+	}
+	return res, nil
+}
+
+//CancelSearchJob cancels running search job
+func CancelSearchJob(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	j, err := jobs.get(r)
+	if err != nil {
+		return nil, err
+	}
+	j.mutex.Lock()
+	if j.status == common.JobRunning {
+		j.status = common.JobCancelled
+	}
+	j.mutex.Unlock()
+	logger.Info(r.Context(), "Cancelling search job %v", j.id)
+	j.cancel()
+	return j.progress(), nil
+}
+
+func (j *job) run(ctx context.Context, hosts []common.HostDetails, gr common.GrepRequest, headers http.Header) {
+	defer j.cancel()
+	statuses := fanOutWithTimeout(ctx, hosts, JobHostTimeout, func(ctx context.Context, h common.HostDetails) (interface{}, error) {
+		return grep(ctx, h, gr, headers)
+	}, func(s *common.HostStatus, res interface{}) {
+		j.mutex.Lock()
+		defer j.mutex.Unlock()
+		if j.truncated {
+			skipped(s)
+		}
+		if res != nil && !j.truncated {
+			for _, g := range res.([]common.GrepResponse) {
+				g.Host = s.Host
+				if MaxJobResults > 0 && j.matches+len(g.Lines) > MaxJobResults {
+					truncate(&g, MaxJobResults-j.matches, gr.After)
+					j.truncated = true
+				}
+				j.matches += len(g.Lines)
+				s.Matches += len(g.Lines)
+				j.results = append(j.results, g)
+				if j.truncated {
+					logger.Info(ctx, "Search job %v reached %v results", j.id, MaxJobResults)
+					j.cancel()
+					break
+				}
+			}
+		}
+		for i := range j.hosts {
+			if j.hosts[i].Endpoint == s.Endpoint && j.hosts[i].Status == common.StatusPending {
+				j.hosts[i] = *s
+				break
+			}
+		}
+	})
+	j.mutex.Lock()
+	if j.truncated {
+		//hosts still waiting for their turn when limit was reached are canceled without calling back
+		for i := range statuses {
+			if statuses[i].Error == context.Canceled.Error() {
+				skipped(&statuses[i])
+			}
+		}
+	}
+	j.hosts = statuses
+	j.finished = time.Now()
+	if j.status == common.JobRunning {
+		j.status = common.JobDone
+	}
+	j.mutex.Unlock()
+	logger.Info(ctx, "Search job %v %v with %v matches", j.id, j.status, j.matches)
+	time.AfterFunc(JobTTL, func() { jobs.remove(j.id) })
+}
+
+func (j *job) progress() *common.JobProgress {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	p := &common.JobProgress{ID: j.id, Status: j.status, Matches: j.matches, Truncated: j.truncated,
+		Started: parser.Millis(j.started), Finished: parser.Millis(j.finished), Hosts: make([]common.HostStatus, len(j.hosts))}
+	copy(p.Hosts, j.hosts)
+	return p
+}
+
+//add rejects job of user who already runs MaxUserJobs jobs
+func (s *jobStore) add(j *job) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	running := 0
+	for _, other := range s.jobs {
+		other.mutex.Lock()
+		if other.user == j.user && other.status == common.JobRunning {
+			running++
+		}
+		other.mutex.Unlock()
+	}
+	if MaxUserJobs > 0 && running >= MaxUserJobs {
+		return fmt.Errorf("Could not submit search job, %v jobs are already running", running)
+	}
+	s.jobs[j.id] = j
+	return nil
+}
+
+func (s *jobStore) remove(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.jobs, id)
+}
+
+//get job by id param, jobs are visible only to user who submitted them
+func (s *jobStore) get(r *http.Request) (*job, error) {
+	id := r.FormValue("id")
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	j, ok := s.jobs[id]
+	if !ok || j.user != requestUser(r) {
+		return nil, fmt.Errorf("Search job '%v' not found", id)
+	}
+	return j, nil
+}
+
+func requestUser(r *http.Request) string {
+	u, _ := r.Context().Value(log.UserKey).(string)
+	return u
+}
@@ -0,0 +1,139 @@
+package resolver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+func TestLocalSearchJob(t *testing.T) {
+	hosts := []common.HostDetails{{LogViewerEndpoint: localLVMEndpoint, Logs: []string{localLog}}}
+	p := submitJob(t, &common.SearchRequest{Hosts: hosts, Value: "bc23456"})
+	p = waitForJob(t, p.ID)
+	if p.Status != common.JobDone || p.Matches == 0 || p.Hosts[0].Status != common.StatusOK {
+		t.Fatalf("wrong progress %+v", p)
+	}
+	req := httptest.NewRequest("GET", "/search-jobs/results?size=1&id="+p.ID, nil)
+	res, err := SearchJobResults(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jr := res.(*common.JobResults)
+	if len(jr.Results) != 1 || jr.Pagination.Total != 1 || len(jr.Results[0].Lines) != p.Matches {
+		t.Fatalf("wrong results %+v", jr)
+	}
+}
+
+func TestSearchJobCancel(t *testing.T) {
+	started, aborted := make(chan bool, 1), make(chan bool, 1)
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/iq-logviewer/lvm/"+model.SearchEndpoint {
+			http.NotFound(w, r)
+			return
+		}
+		ioutil.ReadAll(r.Body)
+		started <- true
+		<-r.Context().Done()
+		aborted <- true
+	}))
+	defer agent.Close()
+
+	hosts := []common.HostDetails{{LogViewerEndpoint: agent.URL + "/iq-logviewer/lvm", Logs: []string{"app.log"}}}
+	p := submitJob(t, &common.SearchRequest{Hosts: hosts, Value: "timeout"})
+	<-started
+	req := httptest.NewRequest("POST", "/search-jobs/cancel?id="+p.ID, nil)
+	if _, err := CancelSearchJob(httptest.NewRecorder(), req); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent call was not aborted")
+	}
+	p = waitForJob(t, p.ID)
+	if p.Status != common.JobCancelled || p.Hosts[0].Status == common.StatusOK {
+		t.Fatalf("wrong progress %+v", p)
+	}
+}
+
+func TestSearchJobResultsOffset(t *testing.T) {
+	hosts := []common.HostDetails{{LogViewerEndpoint: localLVMEndpoint, Logs: []string{localLog}},
+		{LogViewerEndpoint: localLVMEndpoint, Logs: []string{localLog}}}
+	p := waitForJob(t, submitJob(t, &common.SearchRequest{Hosts: hosts, Value: "bc23456"}).ID)
+	res, err := SearchJobResults(httptest.NewRecorder(), httptest.NewRequest("GET", "/search-jobs/results?from=1&size=2&id="+p.ID, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jr := res.(*common.JobResults); len(jr.Results) != 1 || jr.Pagination.From != 1 || jr.Pagination.Total != 2 {
+		t.Fatalf("from should be offset of results, got %+v", jr)
+	}
+}
+
+func TestSearchJobLimits(t *testing.T) {
+	defer func(j, r int) { MaxUserJobs, MaxJobResults = j, r }(MaxUserJobs, MaxJobResults)
+	MaxUserJobs, MaxJobResults = 1, 1
+	started := make(chan bool, 1)
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		started <- true
+		<-r.Context().Done()
+	}))
+	defer agent.Close()
+
+	hosts := []common.HostDetails{{LogViewerEndpoint: agent.URL + "/iq-logviewer/lvm", Logs: []string{"app.log"}}}
+	running := submitJob(t, &common.SearchRequest{Hosts: hosts, Value: "timeout"})
+	<-started
+	b, _ := json.Marshal(&common.SearchRequest{Hosts: hosts, Value: "timeout"})
+	if _, err := SubmitSearchJob(httptest.NewRecorder(), httptest.NewRequest("POST", "/search-jobs", bytes.NewReader(b))); err == nil {
+		t.Fatal("job over running jobs limit of user should be rejected")
+	}
+	CancelSearchJob(httptest.NewRecorder(), httptest.NewRequest("POST", "/search-jobs/cancel?id="+running.ID, nil))
+	waitForJob(t, running.ID)
+
+	local := []common.HostDetails{{LogViewerEndpoint: localLVMEndpoint, Logs: []string{localLog}},
+		{LogViewerEndpoint: localLVMEndpoint, Logs: []string{localLog}}}
+	p := waitForJob(t, submitJob(t, &common.SearchRequest{Hosts: local, Value: "bc23456"}).ID)
+	if p.Status != common.JobDone || !p.Truncated || p.Matches != 1 || p.Hosts[0].Status == common.StatusError ||
+		p.Hosts[1].Status == common.StatusError {
+		t.Fatalf("job should stop at result limit and skip remaining hosts, got %+v", p)
+	}
+}
+
+func submitJob(t *testing.T, sr *common.SearchRequest) *common.JobProgress {
+	b, err := json.Marshal(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/search-jobs", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := SubmitSearchJob(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.(*common.JobProgress)
+}
+
+func waitForJob(t *testing.T, id string) *common.JobProgress {
+	for i := 0; i < 100; i++ {
+		res, err := SearchJobStatus(httptest.NewRecorder(), httptest.NewRequest("GET", "/search-jobs/status?id="+id, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := res.(*common.JobProgress)
+		if p.Finished > 0 {
+			return p
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("job %v not finished", id)
+	return nil
+}
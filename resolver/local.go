@@ -0,0 +1,219 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RomanLorens/logviewer/httpclient"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+var (
+	//SelfAliases host names this instance is reachable by besides hostname, set on server start
+	SelfAliases []string
+	//ServerPort port this instance listens on, set on server start
+	ServerPort int
+	//InstanceID identifies this instance in /support/version handshake
+	InstanceID = newInstanceID()
+	//HandshakeTimeout max time to wait for peer /support/version
+	HandshakeTimeout = 3 * time.Second
+	//localCacheTTL how long resolved endpoints are cached
+	localCacheTTL = 10 * time.Minute
+	localCache    = &endpointCache{entries: make(map[string]cachedLocal)}
+	fqdnOnce      sync.Once
+	fqdn          string
+)
+
+type cachedLocal struct {
+	local   bool
+	expires time.Time
+}
+
+type endpointCache struct {
+	mutex   sync.Mutex
+	entries map[string]cachedLocal
+}
+
+func (c *endpointCache) get(key string) (bool, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return false, false
+	}
+	return e.local, true
+}
+
+func (c *endpointCache) put(key string, local bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[key] = cachedLocal{local: local, expires: time.Now().Add(localCacheTTL)}
+}
+
+func (c *endpointCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[string]cachedLocal)
+}
+
+func newInstanceID() string {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return id.String()
+}
+
+//isLocal endpoint is served by this instance, so logs can be read from local disk.
+//Host name must be exactly hostname, its fqdn or one of self aliases. Otherwise host must resolve to
+//interface ip and either listen on server port or answer /support/version with this instance id
+func isLocal(ctx context.Context, logviewerURL string) bool {
+	u, err := url.Parse(logviewerURL)
+	if err != nil {
+		logger.Error(ctx, "Could not parse %v, %v", logviewerURL, err)
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return false
+	}
+	if isSelfName(host) {
+		return true
+	}
+	key := host + ":" + port(u)
+	if res, ok := localCache.get(key); ok {
+		return res
+	}
+	res := resolveLocal(ctx, u, host)
+	localCache.put(key, res)
+	logger.Info(ctx, "'%v' resolved as local '%v'", logviewerURL, res)
+	return res
+}
+
+//isSelfName host is hostname, short name of hostname or fqdn resolved from it, other names sharing hostname as
+//prefix can be hosts of other domains and are resolved by ip
+func isSelfName(host string) bool {
+	if hostname != "" && (host == hostname || strings.HasPrefix(hostname, host+".")) {
+		return true
+	}
+	if hostname != "" && host == hostFQDN() {
+		return true
+	}
+	for _, a := range SelfAliases {
+		if strings.EqualFold(host, a) {
+			return true
+		}
+	}
+	return false
+}
+
+//hostFQDN canonical name of hostname, resolved once
+func hostFQDN() string {
+	fqdnOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), HandshakeTimeout)
+		defer cancel()
+		cname, err := net.DefaultResolver.LookupCNAME(ctx, hostname)
+		if err != nil {
+			logger.Info(ctx, "Could not resolve fqdn of %v, %v", hostname, err)
+			return
+		}
+		fqdn = strings.ToLower(strings.TrimSuffix(cname, "."))
+	})
+	return fqdn
+}
+
+func resolveLocal(ctx context.Context, u *url.URL, host string) bool {
+	if !hasLocalIP(ctx, host) {
+		return false
+	}
+	if ServerPort > 0 && port(u) == strconv.Itoa(ServerPort) {
+		return true
+	}
+	return handshake(ctx, u)
+}
+
+func hasLocalIP(ctx context.Context, host string) bool {
+	ips := make([]net.IP, 0)
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, HandshakeTimeout)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			logger.Info(ctx, "Could not resolve %v, %v", host, err)
+			return false
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	local := interfaceIPs()
+	for _, ip := range ips {
+		if ip.IsLoopback() {
+			return true
+		}
+		for _, l := range local {
+			if l.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func interfaceIPs() []net.IP {
+	out := make([]net.IP, 0)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		logger.Error(context.Background(), "Could not get interface addresses, %v", err)
+		return out
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			out = append(out, n.IP)
+		}
+	}
+	return out
+}
+
+//handshake asks peer for its instance id, peer on this machine may be another instance with different logs
+func handshake(ctx context.Context, u *url.URL) bool {
+	ctx, cancel := context.WithTimeout(ctx, HandshakeTimeout)
+	defer cancel()
+	b, err := httpclient.Get(ctx, versionURL(u), nil)
+	if err != nil {
+		logger.Info(ctx, "Handshake with %v failed, %v", u.Host, err)
+		return false
+	}
+	var v struct {
+		InstanceID string `json:"instanceId"`
+	}
+	if err = json.Unmarshal(b, &v); err != nil {
+		logger.Info(ctx, "Could not parse handshake response from %v, %v", u.Host, err)
+		return false
+	}
+	return v.InstanceID == InstanceID
+}
+
+//versionURL support version url of logviewer, endpoints point to /<context>/lvm
+func versionURL(u *url.URL) string {
+	path := strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/lvm")
+	return u.Scheme + "://" + u.Host + path + "/support/version"
+}
+
+func port(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
@@ -1,17 +1,23 @@
 package resolver
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/RomanLorens/logviewer-module/api"
 	h "github.com/RomanLorens/logviewer-module/handler"
 	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/agent"
 	"github.com/RomanLorens/logviewer/common"
 	"github.com/RomanLorens/logviewer/httpclient"
 	l "github.com/RomanLorens/logviewer/logger"
@@ -34,48 +40,81 @@ func getHostname() string {
 		h = strings.TrimSpace(string(out))
 	}
 	if len(h) == 0 {
-		//todo
+		h, _ = os.Hostname()
 	}
+	h = strings.ToLower(h)
 	logger.Info(context.Background(), "Resolved hostname as '%v'", h)
 	return h
 }
 
-//Search search
+//Search search all hosts concurrently, results of hosts which answered as before, status of hosts is returned
+//by SearchHosts
 func Search(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	out, err := searchAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return out.Results, nil
+}
+
+//SearchHosts search all hosts concurrently, results with status of every host
+func SearchHosts(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return searchAll(r)
+}
+
+func searchAll(r *http.Request) (*common.SearchResponse, error) {
 	var req common.SearchRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		return nil, fmt.Errorf("Could not parse req body, %v", err)
 	}
-	out := make([]model.GrepResponse, 0)
-	for _, h := range req.Hosts {
-		host := parseHostName(r.Context(), h.LogViewerEndpoint)
-		if isLocal(r.Context(), h.LogViewerEndpoint) {
-			res := lapi.Grep(r.Context(), &model.GrepRequest{Value: req.Value, Logs: h.Logs})
-			for i := range res {
-				res[i].Host = host
-			}
-			out = append(out, res...)
-		} else {
-			url := httpclient.BuildURL(h.LogViewerEndpoint, model.SearchEndpoint)
-			bytes, err := httpclient.Request(r.Context(), url, &model.GrepRequest{Value: req.Value, Logs: h.Logs}, r.Header)
-			if err != nil {
-				continue
-			}
-			var gr []model.GrepResponse
-			if err := json.Unmarshal(bytes, &gr); err != nil {
-				logger.Error(r.Context(), "Could not unmarshal remote response, %v", err)
-				continue
-			}
-			for i := range gr {
-				gr[i].Host = host
-			}
-			out = append(out, gr...)
-		}
+	hosts, gr, err := searchHosts(r.Context(), &req)
+	if err != nil {
+		return nil, err
 	}
+	out := &common.SearchResponse{Results: make([]common.GrepResponse, 0)}
+	out.Hosts = fanOut(r.Context(), hosts, func(ctx context.Context, h common.HostDetails) (interface{}, error) {
+		return grep(ctx, h, *gr, r.Header)
+	}, func(s *common.HostStatus, res interface{}) {
+		if res == nil {
+			return
+		}
+		gr := res.([]common.GrepResponse)
+		for i := range gr {
+			gr[i].Host = s.Host
+			s.Matches += len(gr[i].Lines)
+		}
+		out.Results = append(out.Results, gr...)
+	})
 	return out, nil
 }
 
+func grep(ctx context.Context, h common.HostDetails, req common.GrepRequest, headers http.Header) ([]common.GrepResponse, error) {
+	req.Logs = h.Logs
+	if isLocal(ctx, h.LogViewerEndpoint) {
+		return agent.Grep(ctx, &req)
+	}
+	hs, err := agentHeaders(ctx, h.LogViewerEndpoint, headers)
+	if err != nil {
+		return nil, err
+	}
+	//plain value search uses module endpoint, so agents which were not upgraded yet still answer
+	var post interface{} = &req
+	url := httpclient.BuildURL(h.LogViewerEndpoint, common.GrepEndpoint)
+	if req.Query == "" && !req.TimeWindow.IsSet() && req.Before == 0 && req.After == 0 && !req.Events {
+		post, url = &req.GrepRequest, httpclient.BuildURL(h.LogViewerEndpoint, model.SearchEndpoint)
+	}
+	bytes, err := httpclient.IdempotentRequest(ctx, url, post, hs)
+	if err != nil {
+		return nil, err
+	}
+	var gr []common.GrepResponse
+	if err := json.Unmarshal(bytes, &gr); err != nil {
+		return nil, fmt.Errorf("Could not unmarshal remote response, %v", err)
+	}
+	return gr, nil
+}
+
 //ListLogs list ologs
 func ListLogs(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	var req common.HostDetails
@@ -83,16 +122,25 @@ func ListLogs(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Could not parse req body, %v", err)
 	}
-	host := parseHostName(r.Context(), req.LogViewerEndpoint)
-	if isLocal(r.Context(), req.LogViewerEndpoint) {
-		lds := lapi.ListLogs(r.Context(), &model.ListLogsRequest{Logs: req.Logs})
+	return hostLogs(r.Context(), req, r.Header)
+}
+
+//hostLogs files in directories of host logs
+func hostLogs(ctx context.Context, h common.HostDetails, headers http.Header) ([]model.LogDetails, error) {
+	host := parseHostName(ctx, h.LogViewerEndpoint)
+	if isLocal(ctx, h.LogViewerEndpoint) {
+		lds := lapi.ListLogs(ctx, &model.ListLogsRequest{Logs: h.Logs})
 		for i := range lds {
 			lds[i].Host = host
 		}
 		return lds, nil
 	}
-	url := httpclient.BuildURL(req.LogViewerEndpoint, model.ListLogsEndpoint)
-	res, err := httpclient.Request(r.Context(), url, &model.ListLogsRequest{Logs: req.Logs}, r.Header)
+	url := httpclient.BuildURL(h.LogViewerEndpoint, model.ListLogsEndpoint)
+	hs, err := agentHeaders(ctx, h.LogViewerEndpoint, headers)
+	if err != nil {
+		return nil, err
+	}
+	res, err := httpclient.IdempotentRequest(ctx, url, &model.ListLogsRequest{Logs: h.Logs}, hs)
 	if err != nil {
 		return nil, err
 	}
@@ -113,41 +161,172 @@ func TailLog(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Could not parse req body, %v", err)
 	}
-	host := parseHostName(r.Context(), req.LogViewerEndpoint)
-	if isLocal(r.Context(), req.LogViewerEndpoint) {
-		res, err := lapi.TailLog(r.Context(), req.Log)
-		if err != nil {
-			return nil, err
-		}
-		res.Host = host
-		return res, nil
+	res, err := hostTail(r.Context(), req.LogViewerEndpoint, &req, r.Header)
+	if err != nil {
+		return nil, err
 	}
+	res.Host = parseHostName(r.Context(), req.LogViewerEndpoint)
+	return res, nil
+}
 
-	url := httpclient.BuildURL(req.LogViewerEndpoint, model.TailLogEndpoint)
-	res, err := httpclient.Request(r.Context(), url, &model.LogRequest{Log: req.Log}, r.Header)
+//hostTail tail of log on host, empty endpoint is this logviewer, remote agents must return cursor when cursor is passed,
+//filter is applied here only for agents which did not apply it
+func hostTail(ctx context.Context, endpoint string, req *common.TailLogRequest, headers http.Header) (*common.TailResponse, error) {
+	f, err := agent.NewFilter(req.Filter, req.LogStructure)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint == "" || isLocal(ctx, endpoint) {
+		return agent.Tail(req.Log, req.Cursor, f)
+	}
+	url := httpclient.BuildURL(endpoint, model.TailLogEndpoint)
+	tr := &common.AgentTailRequest{LogRequest: model.LogRequest{Log: req.Log}, Cursor: req.Cursor, Filter: req.Filter,
+		LogStructure: req.LogStructure}
+	hs, err := agentHeaders(ctx, endpoint, headers)
 	if err != nil {
 		return nil, err
 	}
-	var tlr model.TailLogResponse
+	res, err := httpclient.IdempotentRequest(ctx, url, tr, hs)
+	if err != nil {
+		return nil, err
+	}
+	var tlr common.TailResponse
 	if err := json.Unmarshal(res, &tlr); err != nil {
 		return nil, err
 	}
-	tlr.Host = host
+	if req.Cursor != nil && tlr.Cursor == nil {
+		return nil, fmt.Errorf("Logviewer %v does not support tail cursor", endpoint)
+	}
+	if f != nil && !tlr.Filtered {
+		tlr.Lines, tlr.Filtered = f.Lines(tlr.Lines), true
+	}
 	return &tlr, nil
 }
 
-//DownloadLog download log
+//DownloadLog streams log as attachment, only matching lines or events when value or query is set.
+//Takes json body on post or endpoint, log and gzip params on get, whole log downloads support range requests
 func DownloadLog(w http.ResponseWriter, r *http.Request) (interface{}, error) {
-	var req common.TailLogRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	req, err := downloadRequest(r)
 	if err != nil {
-		return nil, fmt.Errorf("Could not parse req body, %v", err)
+		return nil, err
+	}
+	if req.Log == "" {
+		return nil, fmt.Errorf("Must pass log")
+	}
+	if req.Value != "" || req.Query != "" {
+		return nil, downloadMatches(w, r, req)
 	}
 	if isLocal(r.Context(), req.LogViewerEndpoint) {
-		return lapi.DownloadLog(req.Log)
+		return nil, agent.ServeLog(w, r, req.Log, req.Gzip)
 	}
-	url := httpclient.BuildURL(req.LogViewerEndpoint, model.DownloadLogEndpoint)
-	return httpclient.Request(r.Context(), url, &model.LogRequest{Log: req.Log}, r.Header)
+	return nil, proxyDownload(w, r, req)
+}
+
+func downloadRequest(r *http.Request) (*common.DownloadRequest, error) {
+	var req common.DownloadRequest
+	if r.Method == http.MethodGet {
+		req.LogViewerEndpoint = r.FormValue("endpoint")
+		req.Log = r.FormValue("log")
+		req.Gzip = r.FormValue("gzip") == "true"
+		return &req, nil
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("Could not parse req body, %v", err)
+	}
+	return &req, nil
+}
+
+//proxyDownload streams agent response, range headers are passed both ways
+func proxyDownload(w http.ResponseWriter, r *http.Request, req *common.DownloadRequest) error {
+	headers, err := agentHeaders(r.Context(), req.LogViewerEndpoint, r.Header)
+	if err != nil {
+		return err
+	}
+	for _, h := range []string{"Range", "If-Range"} {
+		if v := r.Header.Get(h); v != "" {
+			headers.Set(h, v)
+		}
+	}
+	resp, legacy, err := agentDownload(r.Context(), req.LogViewerEndpoint, req.Log, req.Gzip, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if legacy {
+		//whole file without range support, compressed here when asked
+		w.Header().Set("Accept-Ranges", "none")
+		if req.Gzip {
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Header().Set("Content-Disposition", agent.Attachment(filepath.Base(req.Log)+".gz"))
+			agent.GzipCopy(r.Context(), w, resp.Body)
+			return nil
+		}
+		resp.Header.Del("Accept-Ranges")
+	}
+	for _, h := range []string{"Content-Type", "Content-Length", "Content-Range", "Content-Disposition", "Accept-Ranges",
+		"Last-Modified"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		logger.Error(r.Context(), "Could not stream %v from %v, %v", req.Log, req.LogViewerEndpoint, err)
+	}
+	return nil
+}
+
+//agentDownload streams log from agent. Agents which were not upgraded yet have no agent download, then whole
+//plain file is streamed from module endpoint and legacy is true
+func agentDownload(ctx context.Context, endpoint string, log string, gz bool, headers http.Header) (*http.Response, bool, error) {
+	url := httpclient.BuildURL(endpoint, common.AgentDownloadEndpoint)
+	resp, err := httpclient.Stream(ctx, url, &common.AgentDownloadRequest{Log: log, Gzip: gz}, headers)
+	if !httpclient.NotFound(err) {
+		return resp, false, err
+	}
+	logger.Info(ctx, "No agent download on %v, downloading whole %v from module endpoint", endpoint, log)
+	hs := headers.Clone()
+	hs.Del("Range")
+	hs.Del("If-Range")
+	url = httpclient.BuildURL(endpoint, model.DownloadLogEndpoint)
+	resp, err = httpclient.Stream(ctx, url, &model.LogRequest{Log: log}, hs)
+	return resp, true, err
+}
+
+//downloadMatches matching lines as attachment, range requests are not supported
+func downloadMatches(w http.ResponseWriter, r *http.Request, req *common.DownloadRequest) error {
+	if (req.Query != "" || req.Events) && req.LogStructure == nil {
+		return fmt.Errorf("Must pass log structure for query or events")
+	}
+	h := common.HostDetails{LogViewerEndpoint: req.LogViewerEndpoint, Logs: []string{req.Log}}
+	gr := common.GrepRequest{GrepRequest: model.GrepRequest{Value: req.Value}, Query: req.Query,
+		LogStructure: req.LogStructure, Events: req.Events}
+	res, err := grep(r.Context(), h, gr, r.Header)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, g := range res {
+		for _, line := range g.Lines {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	name := strings.TrimSuffix(filepath.Base(req.Log), filepath.Ext(req.Log)) + "-matches" + filepath.Ext(req.Log)
+	w.Header().Set("Accept-Ranges", "none")
+	if req.Gzip {
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", agent.Attachment(name+".gz"))
+		agent.GzipCopy(r.Context(), w, &buf)
+		return nil
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", agent.Attachment(name))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if _, err := buf.WriteTo(w); err != nil {
+		logger.Error(r.Context(), "Could not write matches of %v, %v", req.Log, err)
+	}
+	return nil
 }
 
 //CollectStatsHandler collect stats
@@ -167,7 +346,11 @@ func CollectStats(ctx context.Context, req *common.CollectStatsRequest, headers
 		return lapi.CollectStats(ctx, &csr)
 	}
 	url := httpclient.BuildURL(req.LogViewerEndpoint, model.CollectStatsEndpoint)
-	bytes, err := httpclient.Request(ctx, url, &csr, headers)
+	hs, err := agentHeaders(ctx, req.LogViewerEndpoint, headers)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := httpclient.IdempotentRequest(ctx, url, &csr, hs)
 	if err != nil {
 		return nil, err
 	}
@@ -185,23 +368,49 @@ func Errors(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Could not parse req body, %v", err)
 	}
-	if isLocal(r.Context(), req.LogViewerEndpoint) {
-		return lapi.Errors(r.Context(), &model.ErrorsRequest{
+	er := &common.AgentErrorsRequest{
+		ErrorsRequest: &model.ErrorsRequest{
 			From:         req.From,
 			Size:         req.Size,
 			StatsRequest: req.StatsRequest,
-		})
+		},
+		TimeWindow: req.TimeWindow,
+		Events:     req.Events,
+		Group:      req.Group,
 	}
+	return hostErrors(r.Context(), req.LogViewerEndpoint, er, r.Header)
+}
 
-	url := httpclient.BuildURL(req.LogViewerEndpoint, model.ErrorsEndpoint)
-	bytes, err := httpclient.Request(r.Context(), url, &model.ErrorsRequest{
-		From:         req.From,
-		Size:         req.Size,
-		StatsRequest: req.StatsRequest,
-	}, r.Header)
+//hostErrors errors or error groups from single agent
+func hostErrors(ctx context.Context, endpoint string, er *common.AgentErrorsRequest, headers http.Header) (interface{}, error) {
+	if isLocal(ctx, endpoint) {
+		if er.Group {
+			return agent.ErrorGroups(ctx, er)
+		}
+		return agent.Errors(ctx, er)
+	}
+
+	hs, err := agentHeaders(ctx, endpoint, headers)
+	if err != nil {
+		return nil, err
+	}
+	//plain errors use module endpoint, so agents which were not upgraded yet still answer
+	var post interface{} = er
+	url := httpclient.BuildURL(endpoint, common.AgentErrorsEndpoint)
+	if !er.TimeWindow.IsSet() && !er.Events && !er.Group && er.Before == 0 {
+		post, url = er.ErrorsRequest, httpclient.BuildURL(endpoint, model.ErrorsEndpoint)
+	}
+	bytes, err := httpclient.IdempotentRequest(ctx, url, post, hs)
 	if err != nil {
 		return nil, err
 	}
+	if er.Group {
+		var groups common.ErrorGroupsPagination
+		if err = json.Unmarshal(bytes, &groups); err != nil {
+			return nil, err
+		}
+		return &groups, nil
+	}
 	var res model.ErrorDetailsPagination
 	if err = json.Unmarshal(bytes, &res); err != nil {
 		return nil, err
@@ -216,12 +425,26 @@ func Stats(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Could not parse req body, %v", err)
 	}
-	if isLocal(r.Context(), sr.LogViewerEndpoint) {
-		return lapi.Stats(r.Context(), &model.StatsRequest{Log: sr.Log, LogStructure: sr.LogStructure})
+	asr := &common.AgentStatsRequest{StatsRequest: sr.StatsRequest, TimeWindow: sr.TimeWindow}
+	return hostStats(r.Context(), sr.LogViewerEndpoint, asr, r.Header)
+}
+
+//hostStats stats from single agent
+func hostStats(ctx context.Context, endpoint string, asr *common.AgentStatsRequest, headers http.Header) (map[string]*model.Stat, error) {
+	if isLocal(ctx, endpoint) {
+		return agent.Stats(ctx, asr)
 	}
 
-	url := httpclient.BuildURL(sr.LogViewerEndpoint, model.StatsEndpoint)
-	bytes, err := httpclient.Request(r.Context(), url, &model.StatsRequest{Log: sr.Log, LogStructure: sr.LogStructure}, r.Header)
+	hs, err := agentHeaders(ctx, endpoint, headers)
+	if err != nil {
+		return nil, err
+	}
+	var post interface{} = asr
+	url := httpclient.BuildURL(endpoint, common.AgentStatsEndpoint)
+	if !asr.TimeWindow.IsSet() {
+		post, url = asr.StatsRequest, httpclient.BuildURL(endpoint, model.StatsEndpoint)
+	}
+	bytes, err := httpclient.IdempotentRequest(ctx, url, post, hs)
 	if err != nil {
 		return nil, err
 	}
@@ -241,13 +464,3 @@ func parseHostName(ctx context.Context, _url string) string {
 	return u.Hostname()
 }
 
-func isLocal(ctx context.Context, logviewerURL string) bool {
-	u, err := url.Parse(logviewerURL)
-	if err != nil {
-		logger.Error(ctx, "Could not parse %v, %v", logviewerURL, err)
-		return true
-	}
-	res := strings.Contains(u.Hostname(), hostname)
-	logger.Info(ctx, "'%v' resolved as local '%v'", logviewerURL, res)
-	return res
-}
@@ -2,14 +2,23 @@ package resolver
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	lvm "github.com/RomanLorens/logviewer-module/handler"
 	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/agent"
 	"github.com/RomanLorens/logviewer/common"
 )
 
@@ -19,6 +28,7 @@ var (
 	remoteLog         = "test-logs/java-app.log"
 	localLog          = "../test-logs/java-app.log"
 	ls                = &model.LogStructure{Date: 0, Level: 2, Message: 6, Reqid: 5, User: 4, DateFormat: "2006-01-02"}
+	lsTime            = &model.LogStructure{Date: 0, Level: 2, Message: 6, Reqid: 5, User: 4, DateFormat: "2006-01-02 15:04:05,000"}
 )
 
 func getLocalEndpoint() string {
@@ -34,11 +44,16 @@ func TestIsLocal(t *testing.T) {
 	if !res {
 		t.Fatal("Should be local")
 	}
-	url = fmt.Sprintf("https://%v.nam.com:8090/iq-logviewer", host)
-	fmt.Println(url)
-	res = isLocal(context.Background(), url)
-	if !res {
-		t.Fatal("Should be local")
+	if f := hostFQDN(); f != "" {
+		url = fmt.Sprintf("https://%v:8090/iq-logviewer", f)
+		fmt.Println(url)
+		if !isLocal(context.Background(), url) {
+			t.Fatal("Fqdn should be local")
+		}
+	}
+	url = fmt.Sprintf("https://%v.otherdc.invalid:8090/iq-logviewer", host)
+	if isLocal(context.Background(), url) {
+		t.Fatal("Hostname in other domain should be remote")
 	}
 	url = "https://some.remote.host:8090/iq-logviewer"
 	fmt.Println(url)
@@ -46,6 +61,63 @@ func TestIsLocal(t *testing.T) {
 	if res {
 		t.Fatal("Should be remote")
 	}
+	url = fmt.Sprintf("https://%v0.invalid:8090/iq-logviewer", host)
+	if isLocal(context.Background(), url) {
+		t.Fatal("Hostname prefix should be remote")
+	}
+}
+
+func TestIsLocalAlias(t *testing.T) {
+	SelfAliases = []string{"logviewer.example.com"}
+	defer func() { SelfAliases = nil }()
+	if !isLocal(context.Background(), "https://LogViewer.example.com/iq-logviewer/lvm") {
+		t.Fatal("Alias should be local")
+	}
+	if isLocal(context.Background(), "https://logviewer2.example.com/iq-logviewer/lvm") {
+		t.Fatal("Should be remote")
+	}
+}
+
+func TestIsLocalEmptyHostname(t *testing.T) {
+	h := hostname
+	hostname = ""
+	defer func() { hostname = h }()
+	if isLocal(context.Background(), "https://some.remote.host:8090/iq-logviewer") {
+		t.Fatal("Should be remote when hostname is unknown")
+	}
+}
+
+func TestIsLocalHandshake(t *testing.T) {
+	defer localCache.clear()
+	peer := func(id string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/iq-logviewer/support/version" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"hostname": "peer", "instanceId": id})
+		}))
+	}
+	self := peer(InstanceID)
+	defer self.Close()
+	other := peer("other-instance")
+	defer other.Close()
+
+	if !isLocal(context.Background(), self.URL+"/iq-logviewer/lvm") {
+		t.Fatal("Instance with same id should be local")
+	}
+	if isLocal(context.Background(), other.URL+"/iq-logviewer/lvm") {
+		t.Fatal("Other instance on same machine should be remote")
+	}
+	//interface ip on server port is this instance
+	other.Close()
+	u, _ := url.Parse(other.URL)
+	ServerPort, _ = strconv.Atoi(u.Port())
+	defer func() { ServerPort = 0 }()
+	localCache.clear()
+	if !isLocal(context.Background(), other.URL+"/iq-logviewer/lvm") {
+		t.Fatal("Loopback on server port should be local")
+	}
 }
 
 func TestLocalSearch(t *testing.T) {
@@ -55,6 +127,105 @@ func TestLocalSearch(t *testing.T) {
 	search(t, &sr)
 }
 
+func TestLocalAppSearch(t *testing.T) {
+	defer useLocalApp()()
+	sr := common.SearchRequest{AppRequest: common.AppRequest{App: "java-app", Env: "sit"}, Value: "bc23456"}
+	search(t, &sr)
+}
+
+func TestLocalQuerySearch(t *testing.T) {
+	hosts := []common.HostDetails{{LogViewerEndpoint: localLVMEndpoint, Logs: []string{localLog}}}
+	sr := common.SearchRequest{Hosts: hosts, Query: "level=ERROR user=bc23456", LogStructure: ls}
+	res := search(t, &sr)
+	if len(res.Results[0].Lines) != 3 {
+		t.Fatalf("expected 3 errors, got %v", len(res.Results[0].Lines))
+	}
+}
+
+func TestLocalWindowSearch(t *testing.T) {
+	hosts := []common.HostDetails{{LogViewerEndpoint: localLVMEndpoint, Logs: []string{localLog}}}
+	sr := common.SearchRequest{Hosts: hosts, Value: "bc23456", LogStructure: lsTime,
+		TimeWindow: window("2021-05-06 11:27:00", "2021-05-06 11:27:30")}
+	res := search(t, &sr)
+	if len(res.Results[0].Lines) != 8 {
+		t.Fatalf("expected 8 lines, got %v", len(res.Results[0].Lines))
+	}
+}
+
+func TestLocalSearchStream(t *testing.T) {
+	hosts := []common.HostDetails{{LogViewerEndpoint: localLVMEndpoint, Logs: []string{localLog}}}
+	b, err := json.Marshal(&common.SearchRequest{Hosts: hosts, Value: "bc23456", MaxResults: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/search-stream", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	if _, err := SearchStream(rr, req); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(rr.Body)
+	var batch common.SearchBatch
+	if err := dec.Decode(&batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(batch.Results) != 1 || len(batch.Results[0].Lines) != 2 {
+		t.Fatalf("expected 2 lines in batch, got %+v", batch.Results)
+	}
+	var trailer common.SearchTrailer
+	if err := dec.Decode(&trailer); err != nil {
+		t.Fatal(err)
+	}
+	if !trailer.Trailer || !trailer.Truncated || trailer.Matches != 2 {
+		t.Fatalf("wrong trailer %+v", trailer)
+	}
+}
+
+func window(from string, to string) common.TimeWindow {
+	f, _ := time.ParseInLocation("2006-01-02 15:04:05", from, time.Local)
+	t, _ := time.ParseInLocation("2006-01-02 15:04:05", to, time.Local)
+	return common.TimeWindow{FromTime: f.Unix(), ToTime: t.Unix()}
+}
+
+func useLocalApp() func() {
+	AppResolver = func(ctx context.Context, req *common.AppRequest) (*common.AppHosts, error) {
+		return &common.AppHosts{App: req.App, Env: req.Env, LogStructure: ls,
+			Hosts: []common.HostDetails{{LogViewerEndpoint: localLVMEndpoint, Logs: []string{localLog}}}}, nil
+	}
+	return func() { AppResolver = nil }
+}
+
+func TestLocalTrace(t *testing.T) {
+	defer useLocalApp()()
+	reqid := "1-01-CV-QCVMW9XPMLMMUMJKKJNTCR1ETMKB9EG133396101@1-249009#6"
+	b, err := json.Marshal(&common.TraceRequest{AppRequest: common.AppRequest{App: "java-app", Env: "sit"}, ReqID: reqid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/trace", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := Trace(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := res.(*common.TraceResponse)
+	if len(tr.Lines) != 4 {
+		t.Fatalf("expected 4 lines, got %v", len(tr.Lines))
+	}
+	for i, l := range tr.Lines {
+		if l.Host == "" || l.LogFile != localLog {
+			t.Fatalf("missing host or file %+v", l)
+		}
+		if i > 0 && tr.Lines[i-1].Time > l.Time {
+			t.Fatal("lines not sorted by time")
+		}
+	}
+}
+
 func TestLocalListLogs(t *testing.T) {
 	host := common.HostDetails{LogViewerEndpoint: localLVMEndpoint, Logs: []string{localLog}}
 	listLogs(t, &host)
@@ -65,6 +236,24 @@ func TestLocalTailLog(t *testing.T) {
 	tailLog(t, &req)
 }
 
+func TestLocalTailLogFilter(t *testing.T) {
+	b, _ := json.Marshal(&common.TailLogRequest{LogViewerEndpoint: localLVMEndpoint, Log: localLog,
+		Filter: &common.TailFilter{Level: "ERROR"}, LogStructure: ls})
+	res, err := TailLog(httptest.NewRecorder(), httptest.NewRequest("POST", "/tail-log", bytes.NewReader(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlr := res.(*common.TailResponse)
+	if len(tlr.Lines) == 0 || !tlr.Filtered {
+		t.Fatalf("expected filtered lines %+v", tlr)
+	}
+	for _, l := range tlr.Lines {
+		if !strings.Contains(l, "|ERROR|") && strings.Contains(l, "|INFO|") {
+			t.Fatalf("line below min level returned %v", l)
+		}
+	}
+}
+
 func TestLocalDownloadLog(t *testing.T) {
 	req := common.TailLogRequest{LogViewerEndpoint: localLVMEndpoint, Log: localLog}
 	downloadLog(t, &req)
@@ -81,13 +270,197 @@ func downloadLog(t *testing.T, r *common.TailLogRequest) {
 	}
 	rr := httptest.NewRecorder()
 
-	res, err := DownloadLog(rr, req)
-	if err != nil {
+	if _, err = DownloadLog(rr, req); err != nil {
 		t.Fatal(err)
 	}
-	if len(res.([]byte)) == 0 {
+	if rr.Body.Len() == 0 {
 		t.Fatal("empty")
 	}
+	if rr.Header().Get("Content-Disposition") != `attachment; filename=java-app.log` {
+		t.Fatalf("wrong content disposition '%v'", rr.Header().Get("Content-Disposition"))
+	}
+}
+
+func TestLocalDownloadLogRange(t *testing.T) {
+	full, err := ioutil.ReadFile(localLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/download-log?log="+url.QueryEscape(localLog)+"&endpoint="+url.QueryEscape(localLVMEndpoint), nil)
+	req.Header.Set("Range", "bytes=100-199")
+	rr := httptest.NewRecorder()
+	if _, err = DownloadLog(rr, req); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusPartialContent || !bytes.Equal(rr.Body.Bytes(), full[100:200]) {
+		t.Fatalf("wrong partial content %v '%v'", rr.Code, rr.Body.String())
+	}
+	if cr := rr.Header().Get("Content-Range"); cr != fmt.Sprintf("bytes 100-199/%v", len(full)) {
+		t.Fatalf("wrong content range '%v'", cr)
+	}
+}
+
+func TestLocalDownloadLogGzip(t *testing.T) {
+	full, err := ioutil.ReadFile(localLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/download-log?gzip=true&log="+url.QueryEscape(localLog)+"&endpoint="+url.QueryEscape(localLVMEndpoint), nil)
+	rr := httptest.NewRecorder()
+	if _, err = DownloadLog(rr, req); err != nil {
+		t.Fatal(err)
+	}
+	gr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(gr)
+	if err != nil || !bytes.Equal(b, full) {
+		t.Fatalf("wrong gzip content, %v", err)
+	}
+	if rr.Header().Get("Content-Disposition") != `attachment; filename=java-app.log.gz` {
+		t.Fatalf("wrong content disposition '%v'", rr.Header().Get("Content-Disposition"))
+	}
+}
+
+func TestProxyDownloadLogRange(t *testing.T) {
+	full, err := ioutil.ReadFile(localLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req common.AgentDownloadRequest
+		if r.URL.Path != "/iq-logviewer/lvm/"+common.AgentDownloadEndpoint || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		agent.ServeLog(w, r, req.Log, req.Gzip)
+	}))
+	defer peer.Close()
+	b, _ := json.Marshal(&common.DownloadRequest{TailLogRequest: common.TailLogRequest{LogViewerEndpoint: peer.URL + "/iq-logviewer/lvm",
+		Log: localLog}})
+	req := httptest.NewRequest("POST", "/download-log", bytes.NewReader(b))
+	req.Header.Set("Range", "bytes=10-")
+	rr := httptest.NewRecorder()
+	if _, err = DownloadLog(rr, req); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusPartialContent || !bytes.Equal(rr.Body.Bytes(), full[10:]) {
+		t.Fatalf("wrong proxied partial content %v", rr.Code)
+	}
+	if rr.Header().Get("Content-Disposition") == "" || rr.Header().Get("Content-Range") == "" {
+		t.Fatalf("missing headers %v", rr.Header())
+	}
+}
+
+//legacyAgent agent which was not upgraded yet and serves only module endpoints
+func legacyAgent() *httptest.Server {
+	mh := lvm.NewHandler(logger)
+	handlers := map[string]func(w http.ResponseWriter, r *http.Request) (interface{}, error){
+		model.SearchEndpoint: mh.Search, model.ErrorsEndpoint: mh.Errors, model.StatsEndpoint: mh.Stats,
+		model.DownloadLogEndpoint: mh.DownloadLog, model.ListLogsEndpoint: mh.ListLogs,
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := handlers[strings.TrimPrefix(r.URL.Path, "/iq-logviewer/lvm/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		res, err := handler(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if res != nil {
+			json.NewEncoder(w).Encode(res)
+		}
+	}))
+}
+
+func TestLegacyAgentSearch(t *testing.T) {
+	peer := legacyAgent()
+	defer peer.Close()
+	search(t, &common.SearchRequest{Hosts: []common.HostDetails{{LogViewerEndpoint: peer.URL + "/iq-logviewer/lvm",
+		Logs: []string{localLog}}}, Value: "bc23456"})
+}
+
+func TestLegacyAgentErrorsStats(t *testing.T) {
+	peer := legacyAgent()
+	defer peer.Close()
+	endpoint := peer.URL + "/iq-logviewer/lvm"
+	errors(t, &common.ErrorsRequest{LogViewerEndpoint: endpoint, StatsRequest: &model.StatsRequest{Log: localLog,
+		LogStructure: ls}, Size: 100})
+	stats(t, &common.StatsRequest{LogViewerEndpoint: endpoint, StatsRequest: &model.StatsRequest{Log: localLog,
+		LogStructure: ls}})
+}
+
+func TestLegacyAgentDownload(t *testing.T) {
+	full, err := ioutil.ReadFile(localLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := legacyAgent()
+	defer peer.Close()
+	for _, gz := range []bool{false, true} {
+		b, _ := json.Marshal(&common.DownloadRequest{TailLogRequest: common.TailLogRequest{
+			LogViewerEndpoint: peer.URL + "/iq-logviewer/lvm", Log: localLog}, Gzip: gz})
+		req := httptest.NewRequest("POST", "/download-log", bytes.NewReader(b))
+		req.Header.Set("Range", "bytes=10-")
+		rr := httptest.NewRecorder()
+		if _, err = DownloadLog(rr, req); err != nil {
+			t.Fatal(err)
+		}
+		body := rr.Body.Bytes()
+		if gz {
+			gr, err := gzip.NewReader(rr.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ = ioutil.ReadAll(gr)
+		}
+		if rr.Code != http.StatusOK || !bytes.Equal(body, full) || rr.Header().Get("Accept-Ranges") != "none" {
+			t.Fatalf("legacy agent should send whole file, gzip %v, status %v", gz, rr.Code)
+		}
+	}
+}
+
+func TestProxyTailCursor(t *testing.T) {
+	legacy := false
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req common.AgentTailRequest
+		if r.URL.Path != "/iq-logviewer/lvm/"+model.TailLogEndpoint || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if legacy {
+			req.Cursor = nil
+		}
+		res, _ := agent.Tail(req.Log, req.Cursor, nil)
+		if legacy {
+			res.Cursor = nil
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer peer.Close()
+	tail := func(c *common.TailCursor) (*common.TailResponse, error) {
+		b, _ := json.Marshal(&common.TailLogRequest{LogViewerEndpoint: peer.URL + "/iq-logviewer/lvm", Log: localLog, Cursor: c})
+		res, err := TailLog(httptest.NewRecorder(), httptest.NewRequest("POST", "/tail-log", bytes.NewReader(b)))
+		if err != nil {
+			return nil, err
+		}
+		return res.(*common.TailResponse), nil
+	}
+	res, err := tail(&common.TailCursor{})
+	if err != nil || len(res.Lines) == 0 || res.Cursor == nil {
+		t.Fatalf("first tail should return lines and cursor %+v, %v", res, err)
+	}
+	if res, err = tail(res.Cursor); err != nil || len(res.Lines) != 0 {
+		t.Fatalf("no new lines expected %+v, %v", res, err)
+	}
+	legacy = true
+	if _, err = tail(res.Cursor); err == nil {
+		t.Fatal("agent without cursor support should fail instead of returning duplicates")
+	}
 }
 
 func TestLocalStats(t *testing.T) {
@@ -99,6 +472,41 @@ func TestLocalStats(t *testing.T) {
 	stats(t, &req)
 }
 
+func TestLocalAppStats(t *testing.T) {
+	AppResolver = func(ctx context.Context, req *common.AppRequest) (*common.AppHosts, error) {
+		return &common.AppHosts{App: req.App, Env: req.Env, LogStructure: ls,
+			Hosts: []common.HostDetails{{LogViewerEndpoint: localLVMEndpoint, Logs: []string{localLog, localLog}}}}, nil
+	}
+	defer func() { AppResolver = nil }()
+	b, err := json.Marshal(&common.AppStatsRequest{AppRequest: common.AppRequest{App: "java-app", Env: "sit"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/app-log-stats", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := AppStats(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	as := res.(*common.AppStatsResponse)
+	if len(as.Logs) != 2 || len(as.Totals) == 0 || as.Hosts[0].Status != common.StatusOK {
+		t.Fatalf("wrong app stats %+v", as)
+	}
+	for user, s := range as.Logs[0].Stats {
+		total := as.Totals[user]
+		if total.Counter != 2*s.Counter || len(total.Errors) != 2*len(s.Errors) {
+			t.Fatalf("totals for %v not merged, %+v vs %+v", user, total, s)
+		}
+		for level, count := range s.Levels {
+			if total.Levels[level] != 2*count {
+				t.Fatalf("%v level %v not merged", user, level)
+			}
+		}
+	}
+}
+
 func TestLocalCollectStats(t *testing.T) {
 	req := common.CollectStatsRequest{
 		StatsRequest: &common.StatsRequest{
@@ -162,6 +570,116 @@ func TestLocalErrors(t *testing.T) {
 	errors(t, &req)
 }
 
+func TestLocalWindowErrors(t *testing.T) {
+	req := common.ErrorsRequest{
+		LogViewerEndpoint: localLVMEndpoint,
+		StatsRequest:      &model.StatsRequest{Log: localLog, LogStructure: lsTime},
+		TimeWindow:        window("2021-04-26 10:20:00", "2021-04-26 10:40:00"),
+		Size:              100,
+	}
+	if windowErrors(t, &req).Pagination.Total == 0 {
+		t.Fatal("should find errors in window")
+	}
+	req.TimeWindow = window("2021-05-06 00:00:00", "2021-05-06 23:59:59")
+	if windowErrors(t, &req).Pagination.Total != 0 {
+		t.Fatal("should not find errors outside window")
+	}
+}
+
+func TestLocalAppErrors(t *testing.T) {
+	defer useLocalApp()()
+	req := &common.AppErrorsRequest{AppRequest: common.AppRequest{App: "java-app", Env: "sit"}, Size: 100}
+	all := appErrors(t, req)
+	if len(all.Errors) == 0 || all.Pagination.Total != len(all.Errors) || all.Next != nil {
+		t.Fatalf("wrong errors %+v", all)
+	}
+	for i := 1; i < len(all.Errors); i++ {
+		if all.Errors[i-1].Time < all.Errors[i].Time {
+			t.Fatal("errors should be sorted latest first")
+		}
+	}
+	req.Size = 2
+	paged := make([]common.AppError, 0)
+	for {
+		page := appErrors(t, req)
+		if page.Pagination.Total != len(all.Errors)-len(paged) {
+			t.Fatalf("wrong total %v after %v errors", page.Pagination.Total, len(paged))
+		}
+		paged = append(paged, page.Errors...)
+		if page.Next == nil {
+			break
+		}
+		req.Next = page.Next
+	}
+	if !reflect.DeepEqual(paged, all.Errors) {
+		t.Fatalf("pages differ from merged errors, %+v", paged)
+	}
+}
+
+func TestLegacyAgentAppErrorsPages(t *testing.T) {
+	peer := legacyAgent()
+	defer peer.Close()
+	AppResolver = func(ctx context.Context, req *common.AppRequest) (*common.AppHosts, error) {
+		return &common.AppHosts{App: req.App, Env: req.Env, LogStructure: ls,
+			Hosts: []common.HostDetails{{LogViewerEndpoint: peer.URL + "/iq-logviewer/lvm", Logs: []string{localLog}}}}, nil
+	}
+	defer func() { AppResolver = nil }()
+	req := &common.AppErrorsRequest{AppRequest: common.AppRequest{App: "java-app", Env: "sit"}, Size: 1}
+	first := appErrors(t, req)
+	if len(first.Errors) != 1 || first.Next == nil || first.Hosts[0].Status != common.StatusOK {
+		t.Fatalf("legacy agent should answer first page %+v", first)
+	}
+	req.Next = first.Next
+	next := appErrors(t, req)
+	if len(next.Errors) != 0 || next.Hosts[0].Status != common.StatusSkipped || next.Hosts[0].Error != errPagingUnsupported.Error() {
+		t.Fatalf("legacy agent should be skipped on next page %+v", next)
+	}
+}
+
+func TestNextPageCursor(t *testing.T) {
+	errs := []common.AppError{{Time: 3}, {Time: 2, Host: "a"}, {Time: 2, Host: "b"}, {Time: 2, Host: "c"}, {Time: 1},
+		{Time: 0}}
+	page := nextPage(errs, &common.AppErrorsCursor{Time: 2, Skip: 2}, 2)
+	if len(page) != 2 || page[0].Host != "c" || page[1].Time != 1 {
+		t.Fatalf("wrong page after cursor %+v", page)
+	}
+	if page = nextPage(errs, &common.AppErrorsCursor{Time: 0}, 2); len(page) != 1 || page[0].Time != 0 {
+		t.Fatalf("errors without time should be last page %+v", page)
+	}
+}
+
+func appErrors(t *testing.T, er *common.AppErrorsRequest) *common.AppErrorsResponse {
+	b, err := json.Marshal(er)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.NewRequest("POST", "/app-errors", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := AppErrors(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.(*common.AppErrorsResponse)
+}
+
+func windowErrors(t *testing.T, er *common.ErrorsRequest) *model.ErrorDetailsPagination {
+	b, err := json.Marshal(er)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.NewRequest("POST", "/errors", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := Errors(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.(*model.ErrorDetailsPagination)
+}
+
 func errors(t *testing.T, er *common.ErrorsRequest) {
 	b, err := json.Marshal(er)
 	if err != nil {
@@ -236,7 +754,7 @@ func tailLog(t *testing.T, r *common.TailLogRequest) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	tlr := res.(*model.TailLogResponse)
+	tlr := res.(*common.TailResponse)
 	if len(tlr.Lines) == 0 {
 		t.Fatal("empty")
 	}
@@ -263,24 +781,42 @@ func listLogs(t *testing.T, hd *common.HostDetails) {
 	}
 }
 
-func search(t *testing.T, sr *common.SearchRequest) {
+func search(t *testing.T, sr *common.SearchRequest) *common.SearchResponse {
 	b, err := json.Marshal(sr)
 	if err != nil {
 		t.Fatal(err)
 	}
-	req, err := http.NewRequest("POST", "/search", bytes.NewReader(b))
+	req, err := http.NewRequest("POST", "/search-hosts", bytes.NewReader(b))
 	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
 
-	res, err := Search(rr, req)
+	res, err := SearchHosts(rr, req)
 	if err != nil {
 		t.Fatal(err)
 	}
-	responses := res.([]model.GrepResponse)
+	resp := res.(*common.SearchResponse)
+	responses := resp.Results
 	if len(responses) == 0 || len(responses[0].Lines) == 0 {
 		t.Fatal("no matches")
 	}
+	for _, s := range resp.Hosts {
+		if s.Status != common.StatusOK {
+			t.Fatalf("host %v failed, %v", s.Host, s.Error)
+		}
+	}
+	return resp
+}
 
+func TestSearchKeepsResultsArray(t *testing.T) {
+	b, _ := json.Marshal(&common.SearchRequest{Hosts: []common.HostDetails{{LogViewerEndpoint: localLVMEndpoint,
+		Logs: []string{localLog}}}, Value: "bc23456"})
+	res, err := Search(httptest.NewRecorder(), httptest.NewRequest("POST", "/search", bytes.NewReader(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gr, ok := res.([]common.GrepResponse); !ok || len(gr) == 0 {
+		t.Fatalf("search should return array of results, got %T", res)
+	}
 }
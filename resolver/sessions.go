@@ -0,0 +1,287 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/agent"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+var (
+	//ClientBuffer messages queued for websocket client, lines of slow client are dropped when buffer is full
+	ClientBuffer = 256
+	//SessionHistory recent lines of shared tail sent to client joining it, at least last batch is kept
+	SessionHistory = 100
+	sessions       = &sessionStore{sessions: make(map[string]*tailSession)}
+)
+
+//tailClient websocket client subscribed to shared tails, every client has own filter and buffer
+type tailClient struct {
+	mutex   sync.Mutex
+	out     chan tailEvent
+	filter  *common.TailFilter
+	matcher *agent.Filter
+	ls      *model.LogStructure
+	dropped int
+}
+
+func newTailClient(filter *common.TailFilter, ls *model.LogStructure) (*tailClient, error) {
+	c := &tailClient{out: make(chan tailEvent, ClientBuffer), ls: ls}
+	if err := c.setFilter(filter); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *tailClient) setFilter(filter *common.TailFilter) error {
+	m, err := agent.NewFilter(filter, c.ls)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.matcher = m
+	c.filter = filter
+	if m == nil {
+		c.filter = nil
+	}
+	return nil
+}
+
+func (c *tailClient) getFilter() *common.TailFilter {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.filter
+}
+
+//send queues event without blocking, lines which do not fit are dropped and counted in next message. Status
+//is not dropped, oldest queued lines are dropped to make room for it
+func (c *tailClient) send(e tailEvent) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e.lines != nil {
+		if e.lines = c.filterLines(e.lines); len(e.lines) == 0 {
+			return
+		}
+	}
+	for i := 0; ; i++ {
+		e.dropped = c.dropped
+		select {
+		case c.out <- e:
+			c.dropped = 0
+			return
+		default:
+		}
+		if len(e.lines) > 0 || i >= cap(c.out) {
+			c.dropped += len(e.lines)
+			return
+		}
+		c.dropOldest()
+	}
+}
+
+//dropOldest drops oldest queued event when it has lines, other events are queued again behind the rest
+func (c *tailClient) dropOldest() {
+	select {
+	case old := <-c.out:
+		if len(old.lines) > 0 {
+			c.dropped += old.dropped + len(old.lines)
+			return
+		}
+		select {
+		case c.out <- old:
+		default:
+		}
+	default:
+	}
+}
+
+//reply queues answer to client message, waits for free buffer
+func (c *tailClient) reply(ctx context.Context, e tailEvent) {
+	select {
+	case c.out <- e:
+	case <-ctx.Done():
+	}
+}
+
+func (c *tailClient) filterLines(lines []common.AppTailLine) []common.AppTailLine {
+	if c.matcher == nil {
+		return lines
+	}
+	raw := make([]string, len(lines))
+	for i, l := range lines {
+		raw[i] = l.Line
+	}
+	out := make([]common.AppTailLine, 0, len(lines))
+	for i, keep := range c.matcher.Keep(raw) {
+		if keep {
+			out = append(out, lines[i])
+		}
+	}
+	return out
+}
+
+//tailSession single upstream tail of host log broadcast to all subscribed clients
+type tailSession struct {
+	key      string
+	host     string
+	endpoint string
+	log      string
+	ls       *model.LogStructure
+	//filter sent upstream, set only when all clients use same filter
+	filter  *sharedFilter
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mutex   sync.Mutex
+	clients map[*tailClient]bool
+	history []tailEvent
+	lines   int
+	status  *common.TailStatus
+}
+
+type sessionStore struct {
+	mutex    sync.Mutex
+	sessions map[string]*tailSession
+}
+
+//subscribe adds client to shared tail of log, first client starts upstream tail. Upstream tail outlives its
+//first client and serves others, so it sends only request id and service token, never credentials of client.
+//Tail of other logviewer fails without service token as agent would reject it anyway
+func (s *sessionStore) subscribe(ctx context.Context, endpoint string, log string, ls *model.LogStructure,
+	c *tailClient) (*tailSession, error) {
+	if ServiceToken == "" && endpoint != "" && !isLocal(ctx, endpoint) {
+		return nil, fmt.Errorf("Could not tail %v on %v, service token not configured", log, endpoint)
+	}
+	key := endpoint + "|" + log
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ts, ok := s.sessions[key]
+	if !ok {
+		sctx, cancel := context.WithCancel(context.Background())
+		ts = &tailSession{key: key, host: tailHost(ctx, endpoint), endpoint: endpoint, log: log, ls: ls,
+			filter: &sharedFilter{}, cancel: cancel, clients: make(map[*tailClient]bool)}
+		s.sessions[key] = ts
+		headers := serviceHeaders(ctx)
+		events := make(chan tailEvent, 16)
+		ts.wg.Add(2)
+		go func() {
+			defer ts.wg.Done()
+			follow(sctx, endpoint, log, ls, ts.filter, headers, events)
+		}()
+		go func() {
+			defer ts.wg.Done()
+			ts.broadcast(sctx, events)
+		}()
+		logger.Info(ctx, "Started shared tail of %v on %v", log, ts.host)
+	}
+	ts.add(c)
+	return ts, nil
+}
+
+//unsubscribe removes client from shared tail, upstream tail is stopped with last client
+func (s *sessionStore) unsubscribe(ctx context.Context, ts *tailSession, c *tailClient) {
+	s.mutex.Lock()
+	ts.mutex.Lock()
+	delete(ts.clients, c)
+	left := len(ts.clients)
+	ts.updateFilter()
+	ts.mutex.Unlock()
+	if left == 0 {
+		delete(s.sessions, ts.key)
+		ts.cancel()
+	}
+	s.mutex.Unlock()
+	if left == 0 {
+		ts.wg.Wait()
+		logger.Info(ctx, "Stopped shared tail of %v on %v", ts.log, ts.host)
+	}
+}
+
+//add subscribes client and sends it last status and recent lines
+func (ts *tailSession) add(c *tailClient) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.clients[c] = true
+	ts.updateFilter()
+	if ts.status != nil {
+		s := *ts.status
+		c.send(tailEvent{status: &s})
+	}
+	for _, e := range ts.history {
+		c.send(e)
+	}
+}
+
+func (ts *tailSession) filterChanged() {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.updateFilter()
+}
+
+//updateFilter filters upstream only when every client has same filter, otherwise clients filter on their own
+func (ts *tailSession) updateFilter() {
+	var filter *common.TailFilter
+	first := true
+	for c := range ts.clients {
+		f := c.getFilter()
+		if !reflect.DeepEqual(c.ls, ts.ls) || (!first && !reflect.DeepEqual(f, filter)) {
+			filter = nil
+			break
+		}
+		filter, first = f, false
+	}
+	ts.filter.set(filter)
+}
+
+//broadcast sends upstream events to all clients, slow clients never block others
+func (ts *tailSession) broadcast(ctx context.Context, events <-chan tailEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-events:
+			ts.mutex.Lock()
+			if e.status != nil {
+				s := *e.status
+				ts.status = &s
+			}
+			if len(e.lines) > 0 {
+				ts.history = append(ts.history, tailEvent{lines: e.lines, cursor: e.cursor})
+				ts.lines += len(e.lines)
+				for ts.lines > SessionHistory && len(ts.history) > 1 {
+					ts.lines -= len(ts.history[0].lines)
+					ts.history = ts.history[1:]
+				}
+			}
+			for c := range ts.clients {
+				c.send(e)
+			}
+			ts.mutex.Unlock()
+		}
+	}
+}
+
+//TailSessions upstream tails shared by websocket clients
+func TailSessions() []common.TailSession {
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+	out := make([]common.TailSession, 0, len(sessions.sessions))
+	for _, ts := range sessions.sessions {
+		ts.mutex.Lock()
+		out = append(out, common.TailSession{Host: ts.host, Endpoint: ts.endpoint, LogFile: ts.log,
+			Subscribers: len(ts.clients), Filtered: ts.filter.get() != nil})
+		ts.mutex.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Endpoint != out[j].Endpoint {
+			return out[i].Endpoint < out[j].Endpoint
+		}
+		return out[i].LogFile < out[j].LogFile
+	})
+	return out
+}
@@ -0,0 +1,182 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/RomanLorens/logger/log"
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+	"github.com/gorilla/websocket"
+)
+
+func TestSharedTailHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/"+model.TailLogEndpoint) {
+			http.NotFound(w, r)
+			return
+		}
+		select {
+		case headers <- r.Header.Clone():
+		default:
+		}
+		json.NewEncoder(w).Encode(&common.TailResponse{Cursor: &common.TailCursor{}})
+	}))
+	defer peer.Close()
+	endpoint := peer.URL + "/iq-logviewer/lvm"
+	defer func(t string, k func(string) bool) { ServiceToken, KnownEndpoint = t, k }(ServiceToken, KnownEndpoint)
+	ServiceToken = "svc-token"
+	KnownEndpoint = func(e string) bool { return e == endpoint }
+
+	ctx := context.WithValue(context.Background(), log.UserKey, "ab12345")
+	ctx = context.WithValue(ctx, log.ReqID, "req-1")
+	client, _ := newTailClient(nil, nil)
+	ts, err := sessions.subscribe(ctx, endpoint, "app.log", nil, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sessions.unsubscribe(ctx, ts, client)
+	select {
+	case h := <-headers:
+		if h.Get("Authorization") != "Bearer svc-token" || h.Get(common.OriginalUserHeader) != "" ||
+			h.Get(common.RequestIDHeader) != "req-1" {
+			t.Fatalf("shared tail should use only service credentials, got %v", h)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream tail not started")
+	}
+}
+
+func TestSharedTailNeedsServiceToken(t *testing.T) {
+	peer := httptest.NewServer(http.NotFoundHandler())
+	defer peer.Close()
+	client, _ := newTailClient(nil, nil)
+	ctx := context.Background()
+	if _, err := sessions.subscribe(ctx, peer.URL+"/iq-logviewer/lvm", "app.log", nil, client); err == nil ||
+		!strings.Contains(err.Error(), "service token not configured") {
+		t.Fatalf("tail of other logviewer without service token should fail, got %v", err)
+	}
+	ts, err := sessions.subscribe(ctx, "", "app.log", nil, client)
+	if err != nil {
+		t.Fatalf("local tail needs no service token, got %v", err)
+	}
+	sessions.unsubscribe(ctx, ts, client)
+}
+
+func TestTailClientKeepsStatus(t *testing.T) {
+	defer func(b int) { ClientBuffer = b }(ClientBuffer)
+	ClientBuffer = 2
+	c, err := newTailClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		c.send(tailEvent{lines: []common.AppTailLine{{Line: "line"}}})
+	}
+	c.send(tailEvent{status: &common.TailStatus{Status: common.StatusError}})
+	first, second := <-c.out, <-c.out
+	if len(first.lines) != 1 || second.status == nil || second.dropped != 2 {
+		t.Fatalf("status should replace oldest lines and count them, got %+v %+v", first, second)
+	}
+}
+
+func TestSharedTail(t *testing.T) {
+	defer func(i, d time.Duration) { TailInterval, MergeDelay = i, d }(TailInterval, MergeDelay)
+	TailInterval, MergeDelay = 10*time.Millisecond, 10*time.Millisecond
+	dir, err := ioutil.TempDir("", "sharedtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(log, []byte("2021-05-06 11:27:01,000|main|INFO|c.App|ab12345|r1|started\n"), 0644)
+
+	done := make(chan bool, 2)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HostTailWS(w, r)
+		done <- true
+	}))
+	defer s.Close()
+	clients := make([]*websocket.Conn, 0)
+	for _, filter := range []*common.TailFilter{nil, {Level: "ERROR"}} {
+		c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		c.WriteJSON(&common.TailLogRequest{Log: log, LogStructure: lsTime, Filter: filter})
+		clients = append(clients, c)
+	}
+	read := func(c *websocket.Conn) common.AppTailLine {
+		for {
+			var m common.AppTailMessage
+			if err := c.ReadJSON(&m); err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Lines) > 0 {
+				return m.Lines[0]
+			}
+		}
+	}
+	if l := read(clients[0]); !strings.HasSuffix(l.Line, "started") {
+		t.Fatalf("wrong first line %+v", l)
+	}
+	if ss := TailSessions(); len(ss) != 1 || ss[0].Subscribers != 2 || ss[0].Filtered {
+		t.Fatalf("one unfiltered upstream tail expected for clients with different filters, got %+v", ss)
+	}
+	f, _ := os.OpenFile(log, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("2021-05-06 11:27:02,000|main|ERROR|c.App|ab12345|r1|failed\n")
+	f.Close()
+	if l := read(clients[1]); !strings.HasSuffix(l.Line, "failed") {
+		t.Fatalf("client filter should apply to shared tail %+v", l)
+	}
+
+	clients[0].Close()
+	<-done
+	for ss := TailSessions(); len(ss) != 1 || ss[0].Subscribers != 1 || !ss[0].Filtered; ss = TailSessions() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	clients[1].Close()
+	<-done
+	if ss := TailSessions(); len(ss) != 0 {
+		t.Fatalf("upstream tail should stop with last client %+v", ss)
+	}
+}
+
+func TestSlowTailClient(t *testing.T) {
+	defer func(b int) { ClientBuffer = b }(ClientBuffer)
+	ClientBuffer = 10
+	fast, _ := newTailClient(nil, nil)
+	ClientBuffer = 2
+	slow, _ := newTailClient(nil, nil)
+	ts := &tailSession{filter: &sharedFilter{}, clients: map[*tailClient]bool{fast: true, slow: true}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan tailEvent)
+	go ts.broadcast(ctx, events)
+	line := func(s string) tailEvent {
+		return tailEvent{lines: []common.AppTailLine{{Line: s}}}
+	}
+	for _, s := range []string{"1", "2", "3", "4"} {
+		events <- line(s)
+		if e := <-fast.out; e.lines[0].Line != s || e.dropped != 0 {
+			t.Fatalf("fast client should get every line %+v", e)
+		}
+	}
+	<-slow.out
+	<-slow.out
+	events <- line("5")
+	<-fast.out
+	if e := <-slow.out; e.dropped != 2 || e.lines[0].Line != "5" {
+		t.Fatalf("slow client should get drop marker before next line %+v", e)
+	}
+}
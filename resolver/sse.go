@@ -0,0 +1,236 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	h "github.com/RomanLorens/logviewer-module/handler"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+var (
+	//SSERetry reconnect delay told to server sent events clients
+	SSERetry = 3 * time.Second
+	//SSEKeepAlive interval of comments keeping idle event stream open through proxies
+	SSEKeepAlive = 15 * time.Second
+)
+
+//sseWriter server sent events stream
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+//newSSE starts event stream, fails when response can not be flushed
+func newSSE(w http.ResponseWriter, retry time.Duration) (*sseWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("Server sent events not supported")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	//nginx buffers responses by default
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	s := &sseWriter{w: w, flusher: flusher}
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", retry/time.Millisecond); err != nil {
+		return nil, err
+	}
+	flusher.Flush()
+	return s, nil
+}
+
+//send writes json event, empty id keeps last event id of client
+func (s *sseWriter) send(id string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Could not marshal event, %v", err)
+	}
+	if id != "" {
+		if _, err = fmt.Fprintf(s.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err = fmt.Fprintf(s.w, "data: %s\n\n", b); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *sseWriter) keepAlive() error {
+	if _, err := fmt.Fprint(s.w, ": keep-alive\n\n"); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+//lastEventID id sent by reconnecting client, lastEventId param for clients which can not set headers
+func lastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.FormValue("lastEventId")
+}
+
+//eventID tail cursor as event id, offset is after fingerprint of file
+func eventID(c *common.TailCursor) string {
+	return fmt.Sprintf("%v@%v", c.File, c.Offset)
+}
+
+func parseEventID(id string) (*common.TailCursor, error) {
+	i := strings.LastIndex(id, "@")
+	if i < 0 {
+		return nil, fmt.Errorf("Wrong event id '%v'", id)
+	}
+	offset, err := strconv.ParseInt(id[i+1:], 10, 64)
+	if err != nil || offset < 0 {
+		return nil, fmt.Errorf("Wrong event id '%v'", id)
+	}
+	return &common.TailCursor{File: id[:i], Offset: offset}, nil
+}
+
+//tailSSERequest tail request from params, log structure of app and env is needed for level, user and reqid filter
+func tailSSERequest(r *http.Request) (*common.TailLogRequest, error) {
+	req := &common.TailLogRequest{Log: r.FormValue("log")}
+	req.LogViewerEndpoint = r.FormValue("endpoint")
+	if req.Log == "" {
+		return nil, fmt.Errorf("Must pass log")
+	}
+	if app, env := r.FormValue("app"), r.FormValue("env"); app != "" || env != "" {
+		hosts, err := appHosts(r.Context(), &common.AppRequest{App: app, Env: env})
+		if err != nil {
+			return nil, err
+		}
+		req.LogStructure = hosts.LogStructure
+	}
+	f := &common.TailFilter{Regex: r.FormValue("regex"), Level: r.FormValue("level"), User: r.FormValue("user"),
+		ReqID: r.FormValue("reqid")}
+	if *f != (common.TailFilter{}) {
+		req.Filter = f
+	}
+	return req, nil
+}
+
+//TailLogSSE server sent events version of host tail websocket with same messages, filter is passed as params
+//and changed by reconnecting. Line events have cursor of log as id, so client reconnecting with Last-Event-ID
+//gets lines written in meantime
+func TailLogSSE(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	req, err := tailSSERequest(r)
+	if err != nil {
+		return nil, err
+	}
+	var resume *common.TailCursor
+	if id := lastEventID(r); id != "" {
+		if resume, err = parseEventID(id); err != nil {
+			return nil, err
+		}
+	}
+	client, err := newTailClient(req.Filter, req.LogStructure)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	//subscribed before catching up so no line is missed, shared lines already sent are skipped
+	ts, err := sessions.subscribe(ctx, req.LogViewerEndpoint, req.Log, req.LogStructure, client)
+	if err != nil {
+		return nil, err
+	}
+	defer sessions.unsubscribe(ctx, ts, client)
+	s, err := newSSE(w, SSERetry)
+	if err != nil {
+		return nil, err
+	}
+	send := func(e tailEvent) error {
+		id := ""
+		if len(e.lines) > 0 && e.cursor != nil {
+			id = eventID(e.cursor)
+		}
+		return s.send(id, &common.AppTailMessage{Lines: e.lines, Status: e.status, Filter: e.filter, Error: e.err,
+			Dropped: e.dropped})
+	}
+	var caught *common.TailCursor
+	if resume != nil {
+		logger.Info(ctx, "Resuming tail of %v from %v", req.Log, eventID(resume))
+		if caught, err = catchUp(ctx, req, client, resume, r.Header, send); err != nil {
+			logger.Error(ctx, "Could not resume tail of %v, %v", req.Log, err)
+			if send(tailEvent{err: fmt.Sprintf("Could not resume tail, %v", err)}) != nil {
+				return nil, nil
+			}
+		}
+	}
+	ticker := time.NewTicker(SSEKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-ticker.C:
+			if err := s.keepAlive(); err != nil {
+				return nil, nil
+			}
+		case e := <-client.out:
+			if caught != nil && e.cursor != nil && e.cursor.File == caught.File && e.cursor.Offset <= caught.Offset {
+				continue
+			}
+			if err := send(e); err != nil {
+				logger.Info(ctx, "Closing tail - %v", err)
+				return nil, nil
+			}
+		}
+	}
+}
+
+//catchUp sends lines written after cursor, returns cursor reached
+func catchUp(ctx context.Context, req *common.TailLogRequest, client *tailClient, cursor *common.TailCursor,
+	headers http.Header, send func(e tailEvent) error) (*common.TailCursor, error) {
+	host := tailHost(ctx, req.LogViewerEndpoint)
+	var last time.Time
+	for {
+		tr := &common.TailLogRequest{Log: req.Log, Cursor: cursor, Filter: client.getFilter(), LogStructure: req.LogStructure}
+		pctx, cancel := context.WithTimeout(ctx, HostTimeout)
+		res, err := hostTail(pctx, req.LogViewerEndpoint, tr, headers)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if res.Rotated || res.Truncated {
+			st := &common.TailStatus{Host: host, Endpoint: req.LogViewerEndpoint, LogFile: req.Log,
+				Status: common.StatusOK, Rotated: res.Rotated, Truncated: res.Truncated}
+			if err = send(tailEvent{status: st}); err != nil {
+				return nil, err
+			}
+		}
+		cursor = res.Cursor
+		if lines := tailLines(res, host, req.Log, req.LogStructure, &last); len(lines) > 0 {
+			if err = send(tailEvent{lines: lines, cursor: cursor}); err != nil {
+				return nil, err
+			}
+		}
+		if !res.More {
+			return cursor, nil
+		}
+	}
+}
+
+//AppsHealthSSE server sent events version of apps health websocket, every health is event. Stream ends after
+//all checks and client reconnects after HealthRetry for next round, event ids continue from Last-Event-ID
+func AppsHealthSSE(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	seq, _ := strconv.Atoi(lastEventID(r))
+	s, err := newSSE(w, HealthRetry)
+	if err != nil {
+		return nil, err
+	}
+	checkHealths(r.Context(), func(health *h.Health) error {
+		seq++
+		return s.send(strconv.Itoa(seq), health)
+	})
+	return nil, nil
+}
@@ -0,0 +1,142 @@
+package resolver
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	h "github.com/RomanLorens/logviewer-module/handler"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+type sseEvent struct {
+	id   string
+	data string
+}
+
+//readEvents reads events of stream containing match until n events or end of stream
+func readEvents(t *testing.T, resp *http.Response, n int, match string) []sseEvent {
+	out := make([]sseEvent, 0)
+	sc := bufio.NewScanner(resp.Body)
+	e := sseEvent{}
+	for len(out) < n && sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			e.id = line[4:]
+		case strings.HasPrefix(line, "data: "):
+			e.data = line[6:]
+		case line == "" && e.data != "":
+			if strings.Contains(e.data, match) {
+				out = append(out, e)
+			}
+			e = sseEvent{}
+		}
+	}
+	return out
+}
+
+func sseGet(t *testing.T, u string, lastID string) *http.Response {
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("wrong content type %v", ct)
+	}
+	return resp
+}
+
+func TestTailLogSSE(t *testing.T) {
+	defer func(i time.Duration) { TailInterval = i }(TailInterval)
+	TailInterval = 10 * time.Millisecond
+	dir, err := ioutil.TempDir("", "tailsse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(log, []byte("first\n"), 0644)
+
+	done := make(chan bool, 2)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		TailLogSSE(w, r)
+		done <- true
+	}))
+	defer s.Close()
+	u := s.URL + "?log=" + url.QueryEscape(log)
+
+	resp := sseGet(t, u, "")
+	events := readEvents(t, resp, 1, `"lines"`)
+	resp.Body.Close()
+	<-done
+	var m common.AppTailMessage
+	if len(events) != 1 || events[0].id == "" || json.Unmarshal([]byte(events[0].data), &m) != nil ||
+		len(m.Lines) != 1 || m.Lines[0].Line != "first" {
+		t.Fatalf("first line with cursor id expected %+v", events)
+	}
+
+	f, _ := os.OpenFile(log, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("second\nthird\n")
+	f.Close()
+	resp = sseGet(t, u, events[0].id)
+	events = readEvents(t, resp, 1, `"lines"`)
+	resp.Body.Close()
+	<-done
+	m = common.AppTailMessage{}
+	if len(events) != 1 || json.Unmarshal([]byte(events[0].data), &m) != nil || len(m.Lines) != 2 ||
+		m.Lines[0].Line != "second" || m.Lines[1].Line != "third" {
+		t.Fatalf("only lines written after last event expected %+v", events)
+	}
+	if c, err := parseEventID(events[0].id); err != nil || c.Offset != int64(len("first\nsecond\nthird\n")) {
+		t.Fatalf("wrong event id %v, %v", events[0].id, err)
+	}
+}
+
+func TestAppsHealthSSE(t *testing.T) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer app.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+	HealthChecks = func() []h.Health {
+		return []h.Health{{Host: app.URL + "/health", App: "app", Env: "sit"}, {Host: down.URL, App: "app", Env: "uat"}}
+	}
+	defer func() { HealthChecks = nil }()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AppsHealthSSE(w, r)
+	}))
+	defer s.Close()
+
+	resp := sseGet(t, s.URL, "5")
+	defer resp.Body.Close()
+	events := readEvents(t, resp, 3, "")
+	if len(events) != 2 {
+		t.Fatalf("stream should end after all checks %+v", events)
+	}
+	status := make(map[string]int)
+	for i, e := range events {
+		var health h.Health
+		if err := json.Unmarshal([]byte(e.data), &health); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"6", "7"}[i]; e.id != want {
+			t.Fatalf("event ids should continue from last event id, got %v", e.id)
+		}
+		status[health.Env] = health.Status
+	}
+	if status["sit"] != 200 || status["uat"] != 0 {
+		t.Fatalf("wrong health statuses %v", status)
+	}
+}
@@ -0,0 +1,113 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+//AppStats merges stats of all hosts and logs of application, returns totals with per log breakdown
+func AppStats(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req common.AppStatsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse req body, %v", err)
+	}
+	app, err := appHosts(r.Context(), &req.AppRequest)
+	if err != nil {
+		return nil, err
+	}
+	if app.LogStructure == nil {
+		return nil, fmt.Errorf("Missing log structure for %v %v", app.App, app.Env)
+	}
+	return appStats(r.Context(), app, req.TimeWindow, r.Header), nil
+}
+
+//appStats stats of all hosts and logs of application merged into totals
+func appStats(ctx context.Context, app *common.AppHosts, window common.TimeWindow, headers http.Header) *common.AppStatsResponse {
+	out := &common.AppStatsResponse{App: app.App, Env: app.Env, Totals: make(map[string]*model.Stat),
+		Logs: make([]common.LogStats, 0)}
+	out.Hosts = fanOut(ctx, app.Hosts, func(ctx context.Context, h common.HostDetails) (interface{}, error) {
+		return logsStats(ctx, h, app.LogStructure, window, headers)
+	}, func(s *common.HostStatus, res interface{}) {
+		if res == nil {
+			return
+		}
+		for _, st := range res.([]common.LogStats) {
+			st.Host = s.Host
+			for _, v := range st.Stats {
+				s.Matches += v.Counter
+			}
+			out.Logs = append(out.Logs, st)
+			mergeStats(out.Totals, st.Stats)
+		}
+	})
+	sort.SliceStable(out.Logs, func(i, j int) bool {
+		if out.Logs[i].Host != out.Logs[j].Host {
+			return out.Logs[i].Host < out.Logs[j].Host
+		}
+		return out.Logs[i].LogFile < out.Logs[j].LogFile
+	})
+	for _, v := range out.Totals {
+		sortReqIDs(v.Errors)
+		sortReqIDs(v.Warnings)
+	}
+	return out
+}
+
+//logsStats stats from every log of host, logs are queried concurrently
+func logsStats(ctx context.Context, h common.HostDetails, ls *model.LogStructure, window common.TimeWindow,
+	headers http.Header) ([]common.LogStats, error) {
+	out := make([]common.LogStats, len(h.Logs))
+	errs := make([]error, len(h.Logs))
+	var wg sync.WaitGroup
+	for i, log := range h.Logs {
+		wg.Add(1)
+		go func(i int, log string) {
+			defer wg.Done()
+			asr := &common.AgentStatsRequest{StatsRequest: &model.StatsRequest{Log: log, LogStructure: ls}, TimeWindow: window}
+			out[i] = common.LogStats{Endpoint: h.LogViewerEndpoint, LogFile: log}
+			out[i].Stats, errs[i] = hostStats(ctx, h.LogViewerEndpoint, asr, headers)
+		}(i, log)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("Could not get stats from %v, %v", h.Logs[i], err)
+		}
+	}
+	return out, nil
+}
+
+//mergeStats adds per user counters of src to dst
+func mergeStats(dst map[string]*model.Stat, src map[string]*model.Stat) {
+	for user, s := range src {
+		d, ok := dst[user]
+		if !ok {
+			d = &model.Stat{Levels: make(map[string]int)}
+			dst[user] = d
+		}
+		d.Counter += s.Counter
+		for level, count := range s.Levels {
+			d.Levels[level] += count
+		}
+		if s.LastTime > d.LastTime {
+			d.LastTime = s.LastTime
+		}
+		d.Errors = append(d.Errors, s.Errors...)
+		d.Warnings = append(d.Warnings, s.Warnings...)
+	}
+}
+
+//sortReqIDs latest first
+func sortReqIDs(ids []model.ReqID) {
+	sort.SliceStable(ids, func(i, j int) bool {
+		return ids[i].Date > ids[j].Date
+	})
+}
@@ -0,0 +1,119 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/RomanLorens/logviewer/common"
+)
+
+//SearchStream streams search results as ndjson batch per host, ends with trailer record
+func SearchStream(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req common.SearchRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse req body, %v", err)
+	}
+	if req.MaxResults < 0 {
+		return nil, fmt.Errorf("maxResults can not be negative")
+	}
+	hosts, gr, err := searchHosts(r.Context(), &req)
+	if err != nil {
+		return nil, err
+	}
+	if req.MaxResults > 0 {
+		//one more to know if results were truncated
+		gr.MaxResults = req.MaxResults + 1
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	var writeErr error
+	write := func(v interface{}) {
+		if writeErr != nil {
+			return
+		}
+		if writeErr = enc.Encode(v); writeErr != nil {
+			logger.Error(ctx, "Could not write search batch, %v", writeErr)
+			cancel()
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	trailer := common.SearchTrailer{Trailer: true}
+	trailer.Hosts = fanOut(ctx, hosts, func(ctx context.Context, h common.HostDetails) (interface{}, error) {
+		return grep(ctx, h, *gr, r.Header)
+	}, func(s *common.HostStatus, res interface{}) {
+		if trailer.Truncated {
+			skipped(s)
+		}
+		batch := common.SearchBatch{Results: make([]common.GrepResponse, 0)}
+		if res != nil && !trailer.Truncated {
+			for _, g := range res.([]common.GrepResponse) {
+				g.Host = s.Host
+				if req.MaxResults > 0 && trailer.Matches+len(g.Lines) > req.MaxResults {
+					truncate(&g, req.MaxResults-trailer.Matches, req.After)
+					trailer.Truncated = true
+				}
+				trailer.Matches += len(g.Lines)
+				s.Matches += len(g.Lines)
+				batch.Results = append(batch.Results, g)
+				if trailer.Truncated {
+					logger.Info(ctx, "Search stream reached %v results", req.MaxResults)
+					cancel()
+					break
+				}
+			}
+		}
+		batch.Status = *s
+		write(&batch)
+	})
+	if trailer.Truncated {
+		//hosts still waiting for their turn when limit was reached are canceled without calling back
+		for i := range trailer.Hosts {
+			if trailer.Hosts[i].Error == context.Canceled.Error() {
+				skipped(&trailer.Hosts[i])
+			}
+		}
+	}
+	write(&trailer)
+	return nil, nil
+}
+
+//skipped host which did not answer before result limit was reached, its cancel is not an error
+func skipped(s *common.HostStatus) {
+	if s.Status != common.StatusOK {
+		s.Status, s.Error = common.StatusSkipped, "result limit reached"
+	}
+}
+
+//truncate keeps first n matched lines and context blocks of them with after context of last kept match
+func truncate(g *common.GrepResponse, n int, after int) {
+	g.Lines = g.Lines[:n]
+	if len(g.Blocks) == 0 {
+		return
+	}
+	blocks := make([]common.ContextBlock, 0, len(g.Blocks))
+	for _, b := range g.Blocks {
+		if n == 0 {
+			break
+		}
+		if len(b.Matches) > n {
+			if end := b.Matches[n-1] + after + 1; end < len(b.Lines) {
+				b.Lines = b.Lines[:end]
+			}
+			b.Matches = b.Matches[:n]
+		}
+		n -= len(b.Matches)
+		blocks = append(blocks, b)
+	}
+	g.Blocks = blocks
+}
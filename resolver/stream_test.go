@@ -0,0 +1,53 @@
+package resolver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+)
+
+func TestTruncateKeepsAfterContext(t *testing.T) {
+	g := common.GrepResponse{GrepResponse: model.GrepResponse{Lines: []string{"m1", "m2", "m3"}},
+		Blocks: []common.ContextBlock{{Start: 1, Lines: []string{"b", "m1", "a", "m2", "a", "a", "m3", "a"},
+			Matches: []int{1, 3, 6}}}}
+	truncate(&g, 2, 2)
+	b := g.Blocks[0]
+	if !reflect.DeepEqual(g.Lines, []string{"m1", "m2"}) || !reflect.DeepEqual(b.Matches, []int{1, 3}) ||
+		!reflect.DeepEqual(b.Lines, []string{"b", "m1", "a", "m2", "a", "a"}) {
+		t.Fatalf("wrong truncated block %+v", g)
+	}
+}
+
+func TestSearchStreamSkipsHosts(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/iq-logviewer/lvm/"+model.SearchEndpoint {
+			http.NotFound(w, r)
+			return
+		}
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+	hosts := []common.HostDetails{{LogViewerEndpoint: localLVMEndpoint, Logs: []string{localLog}},
+		{LogViewerEndpoint: slow.URL + "/iq-logviewer/lvm", Logs: []string{"app.log"}}}
+	b, _ := json.Marshal(&common.SearchRequest{Hosts: hosts, Value: "bc23456", MaxResults: 1})
+	rr := httptest.NewRecorder()
+	if _, err := SearchStream(rr, httptest.NewRequest("POST", "/search-stream", bytes.NewReader(b))); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(rr.Body)
+	var trailer common.SearchTrailer
+	for dec.More() {
+		if err := dec.Decode(&trailer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !trailer.Truncated || trailer.Hosts[0].Status != common.StatusOK || trailer.Hosts[1].Status != common.StatusSkipped {
+		t.Fatalf("host not needed for limit should be skipped, got %+v", trailer)
+	}
+}
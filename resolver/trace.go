@@ -0,0 +1,56 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/RomanLorens/logviewer-module/model"
+	"github.com/RomanLorens/logviewer/common"
+	"github.com/RomanLorens/logviewer/parser"
+)
+
+//Trace merges lines of request id from all application hosts into one timeline
+func Trace(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req common.TraceRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse req body, %v", err)
+	}
+	if req.ReqID == "" {
+		return nil, fmt.Errorf("Must pass reqid")
+	}
+	app, err := appHosts(r.Context(), &req.AppRequest)
+	if err != nil {
+		return nil, err
+	}
+	if app.LogStructure == nil {
+		return nil, fmt.Errorf("Missing log structure for %v %v", app.App, app.Env)
+	}
+	gr := common.GrepRequest{GrepRequest: model.GrepRequest{Value: req.ReqID}}
+	out := &common.TraceResponse{Lines: make([]common.TraceLine, 0)}
+	out.Hosts = fanOut(r.Context(), app.Hosts, func(ctx context.Context, h common.HostDetails) (interface{}, error) {
+		return grep(ctx, h, gr, r.Header)
+	}, func(s *common.HostStatus, res interface{}) {
+		if res == nil {
+			return
+		}
+		for _, gr := range res.([]common.GrepResponse) {
+			for _, line := range gr.Lines {
+				l := parser.Parse(line, app.LogStructure)
+				if l == nil || l.ReqID != req.ReqID {
+					continue
+				}
+				out.Lines = append(out.Lines, common.TraceLine{Time: parser.Millis(l.Time), Date: l.Date,
+					Host: s.Host, LogFile: gr.LogFile, Line: line})
+				s.Matches++
+			}
+		}
+	})
+	sort.SliceStable(out.Lines, func(i, j int) bool {
+		return out.Lines[i].Time < out.Lines[j].Time
+	})
+	return out, nil
+}
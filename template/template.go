@@ -7,6 +7,7 @@ import (
 	"strings"
 	"text/template"
 
+	"github.com/RomanLorens/logviewer-module/model"
 	"github.com/RomanLorens/logviewer/common"
 )
 
@@ -19,33 +20,77 @@ func StatsTemplate(data []common.Stats, path string) (string, error) {
 
 	_data := make([]common.StatsTemplate, 0, len(data))
 	for i, s := range data {
-		errors := 0
-		ur := make([]common.UserTotalRequests, 0, len(s.Stats.Users))
-		for user, userLevels := range s.Stats.Users {
-			totalReq := 0
-			for level, count := range userLevels {
-				if strings.Contains(level, "ERROR") || strings.Contains(level, "WARN") {
-					errors += count
-				}
-				totalReq += count
-			}
-			ur = append(ur, common.UserTotalRequests{User: user, Count: totalReq, Level: userLevels})
-		}
-		sort.Slice(ur, func(i, j int) bool {
-			return ur[i].Count > ur[j].Count
-		})
-		_data = append(_data, common.StatsTemplate{Stats: s, TotalErrors: errors, Users: ur})
+		_data = append(_data, statsTemplate(s))
 		_data[i].LogPath = filepath.Base(s.LogPath)
 	}
-
 	sort.Slice(_data, func(i, j int) bool {
 		return _data[i].App+_data[i].Env < _data[j].App+_data[j].Env
 	})
 
+	apps := make([]common.StatsTemplate, 0)
+	for _, s := range appTotals(data) {
+		apps = append(apps, statsTemplate(s))
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].App+apps[i].Env < apps[j].App+apps[j].Env
+	})
+
 	buf := new(bytes.Buffer)
-	err = t.Execute(buf, _data)
+	err = t.Execute(buf, common.StatsEmail{Apps: apps, Logs: _data})
 	if err != nil {
 		return "", err
 	}
 	return buf.String(), nil
 }
+
+func statsTemplate(s common.Stats) common.StatsTemplate {
+	errors := 0
+	ur := make([]common.UserTotalRequests, 0, len(s.Stats.Users))
+	for user, userLevels := range s.Stats.Users {
+		totalReq := 0
+		for level, count := range userLevels {
+			if strings.Contains(level, "ERROR") || strings.Contains(level, "WARN") {
+				errors += count
+			}
+			totalReq += count
+		}
+		ur = append(ur, common.UserTotalRequests{User: user, Count: totalReq, Level: userLevels})
+	}
+	sort.Slice(ur, func(i, j int) bool {
+		return ur[i].Count > ur[j].Count
+	})
+	return common.StatsTemplate{Stats: s, TotalErrors: errors, Users: ur}
+}
+
+//appTotals merges stats of all hosts and logs per application, env and date
+func appTotals(data []common.Stats) []common.Stats {
+	totals := make(map[string]*common.Stats)
+	keys := make([]string, 0)
+	for _, s := range data {
+		key := s.App + "|" + s.Env + "|" + s.Date
+		t, ok := totals[key]
+		if !ok {
+			t = &common.Stats{App: s.App, Env: s.Env, Date: s.Date,
+				Stats: &model.CollectStatsRsults{Users: make(map[string]map[string]int)}}
+			totals[key] = t
+			keys = append(keys, key)
+		}
+		if s.Stats == nil {
+			continue
+		}
+		t.Stats.TotalRequests += s.Stats.TotalRequests
+		for user, levels := range s.Stats.Users {
+			if t.Stats.Users[user] == nil {
+				t.Stats.Users[user] = make(map[string]int)
+			}
+			for level, count := range levels {
+				t.Stats.Users[user][level] += count
+			}
+		}
+	}
+	out := make([]common.Stats, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *totals[k])
+	}
+	return out
+}
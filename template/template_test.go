@@ -28,6 +28,25 @@ func TestStatsTemplate(t *testing.T) {
 	}
 }
 
+func TestAppTotals(t *testing.T) {
+	users := map[string]map[string]int{"us12345": {"INFO": 5, "ERROR": 1}}
+	stats := []common.Stats{
+		{App: "Training", LogPath: "/app/out.log", Env: "sit", Date: "2021-01-01", Stats: &model.CollectStatsRsults{TotalRequests: 6, Users: users}},
+		{App: "Training", LogPath: "/app/out2.log", Env: "sit", Date: "2021-01-01", Stats: &model.CollectStatsRsults{TotalRequests: 6, Users: users}},
+		{App: "Training", LogPath: "/app/out.log", Env: "uat", Date: "2021-01-01", Stats: &model.CollectStatsRsults{TotalRequests: 3}},
+	}
+	totals := appTotals(stats)
+	if len(totals) != 2 {
+		t.Fatalf("expected 2 apps, got %v", totals)
+	}
+	if totals[0].Stats.TotalRequests != 12 || totals[0].Stats.Users["us12345"]["ERROR"] != 2 {
+		t.Fatalf("wrong totals %+v", totals[0].Stats)
+	}
+	if users["us12345"]["ERROR"] != 1 {
+		t.Fatal("source stats should not change")
+	}
+}
+
 func TestRoutines(t *testing.T) {
 	var wg sync.WaitGroup
 	var mutex = &sync.Mutex{}
@@ -0,0 +1,164 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+//Options tls client options
+type Options struct {
+	//CAFile pem bundle of trusted CAs, system roots when empty
+	CAFile string `json:"caFile"`
+	//CertFile and KeyFile client certificate for mutual tls
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	//Pins base64 sha256 of certificate public key per host, some certificate in chain must match
+	Pins map[string][]string `json:"pins"`
+	//Insecure skips certificate verification, pins are still checked
+	Insecure bool `json:"insecureSkipVerify"`
+}
+
+//Client tls config verifying server certificates
+func Client(o *Options) (*tls.Config, error) {
+	if o == nil {
+		o = &Options{}
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: o.Insecure}
+	if o.CAFile != "" {
+		pool, err := LoadCAs(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load client certificate %v, %v", o.CertFile, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if len(o.Pins) > 0 {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			//ip addresses are not sent as server name, pins of unknown host can't be skipped
+			if cs.ServerName == "" {
+				return fmt.Errorf("Could not check pinned keys, server name of connection is unknown")
+			}
+			return o.VerifyPins(cs.ServerName, cs.PeerCertificates)
+		}
+	}
+	return cfg, nil
+}
+
+//CheckHosts pins of Client config are looked up by server name, so hosts given as ip address are rejected when
+//pins are set. Connections dialed by DialTLS are checked by dialed host and need no check
+func (o *Options) CheckHosts(hosts []string) error {
+	if o == nil || len(o.Pins) == 0 {
+		return nil
+	}
+	for _, h := range hosts {
+		host := h
+		if hh, _, err := net.SplitHostPort(h); err == nil {
+			host = hh
+		}
+		if net.ParseIP(host) != nil {
+			return fmt.Errorf("Pinned keys of %v can't be checked, ip address is not sent as server name", h)
+		}
+	}
+	return nil
+}
+
+//DialTLS dials tls connection checking pins of dialed host, ip addresses are not sent as server name
+//so pins of ip endpoints can be checked only here
+func DialTLS(o *Options, cfg *tls.Config, d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		c := cfg.Clone()
+		if c.ServerName == "" {
+			c.ServerName = host
+		}
+		c.VerifyConnection = nil
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tc := tls.Client(conn, c)
+		if err = tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if o != nil {
+			if err = o.VerifyPins(host, tc.ConnectionState().PeerCertificates); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return tc, nil
+	}
+}
+
+//LoadCAs cert pool from pem bundle
+func LoadCAs(file string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read CA bundle %v, %v", file, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("No certificates found in CA bundle %v", file)
+	}
+	return pool, nil
+}
+
+//LoadPins pins per host from json file like {"host": ["base64 sha256"]}
+func LoadPins(file string) (map[string][]string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read pins file %v, %v", file, err)
+	}
+	var pins map[string][]string
+	if err = json.Unmarshal(b, &pins); err != nil {
+		return nil, fmt.Errorf("Could not parse pins file %v, %v", file, err)
+	}
+	return pins, nil
+}
+
+//Pin base64 sha256 of certificate subject public key info
+func Pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+//VerifyPins some certificate in chain matches pins of host, hosts without pins pass
+func (o *Options) VerifyPins(host string, certs []*x509.Certificate) error {
+	var pins []string
+	for h, p := range o.Pins {
+		if strings.EqualFold(h, host) {
+			pins = p
+			break
+		}
+	}
+	if len(pins) == 0 {
+		return nil
+	}
+	for _, c := range certs {
+		pin := Pin(c)
+		for _, p := range pins {
+			if p == pin {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Certificate of %v does not match pinned keys", host)
+}
@@ -0,0 +1,106 @@
+package tlsconfig
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func get(t *testing.T, s *httptest.Server, o *Options) error {
+	cfg, err := Client(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DialTLSContext: DialTLS(o, cfg, &net.Dialer{})}}
+	resp, err := c.Get(s.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func caFile(t *testing.T, s *httptest.Server) string {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClientVerifiesServer(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	if err := get(t, s, nil); err == nil {
+		t.Fatal("untrusted certificate should be rejected")
+	}
+	if err := get(t, s, &Options{CAFile: caFile(t, s)}); err != nil {
+		t.Fatalf("certificate signed by CA bundle should pass, %v", err)
+	}
+	if err := get(t, s, &Options{Insecure: true}); err != nil {
+		t.Fatalf("insecure mode should skip verification, %v", err)
+	}
+}
+
+func TestClientPins(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+	ca := caFile(t, s)
+
+	pinned := &Options{CAFile: ca, Pins: map[string][]string{"127.0.0.1": {Pin(s.Certificate())}}}
+	if err := get(t, s, pinned); err != nil {
+		t.Fatalf("pinned key should pass, %v", err)
+	}
+	wrong := &Options{CAFile: ca, Pins: map[string][]string{"127.0.0.1": {"AAAA"}}}
+	if err := get(t, s, wrong); err == nil {
+		t.Fatal("wrong pin should be rejected")
+	}
+	wrong.Insecure = true
+	if err := get(t, s, wrong); err == nil {
+		t.Fatal("pins should be checked in insecure mode")
+	}
+}
+
+func TestClientPinsWithoutServerName(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+	o := &Options{CAFile: caFile(t, s), Pins: map[string][]string{"127.0.0.1": {Pin(s.Certificate())}}}
+	cfg, err := Client(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	if resp, err := c.Get(s.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("pins of ip host can't be checked by server name and connection should fail")
+	}
+	if err := o.CheckHosts([]string{"127.0.0.1:27017"}); err == nil {
+		t.Fatal("ip host should be rejected when pins are set")
+	}
+	if err := o.CheckHosts([]string{"db1.example.com:27017"}); err != nil {
+		t.Fatalf("host name should pass, %v", err)
+	}
+	if err := (&Options{}).CheckHosts([]string{"127.0.0.1:27017"}); err != nil {
+		t.Fatalf("ip host without pins should pass, %v", err)
+	}
+}
+
+func TestClientMissingFiles(t *testing.T) {
+	if _, err := Client(&Options{CAFile: "missing.pem"}); err == nil {
+		t.Fatal("missing CA bundle should fail")
+	}
+	if _, err := Client(&Options{CertFile: "missing.crt", KeyFile: "missing.key"}); err == nil {
+		t.Fatal("missing client certificate should fail")
+	}
+}